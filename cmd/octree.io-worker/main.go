@@ -2,12 +2,28 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"log"
+	"net/http"
+	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"octree.io-worker/internal/broker"
 	"octree.io-worker/internal/clients"
+	"octree.io-worker/internal/devserver"
+	"octree.io-worker/internal/migrations"
+	"octree.io-worker/internal/repository"
+	"octree.io-worker/internal/utils"
 	"octree.io-worker/internal/workers"
 )
 
@@ -17,71 +33,383 @@ func failOnError(err error, msg string) {
 	}
 }
 
+// connectBroker connects to the broker selected by MESSAGE_BROKER, which is
+// one of amqp (the default), nats or redis.
+func connectBroker() (broker.Broker, error) {
+	switch kind := utils.GetEnv("MESSAGE_BROKER", "amqp"); kind {
+	case "amqp":
+		conn, err := clients.GetRabbitMQConnection()
+		if err != nil {
+			return nil, err
+		}
+		return broker.NewAMQPBroker(conn), nil
+
+	case "nats":
+		nc, err := clients.GetNatsConnection()
+		if err != nil {
+			return nil, err
+		}
+		return broker.NewJetStreamBroker(nc, utils.GetEnvDuration("NATS_ACK_WAIT", 10*time.Minute))
+
+	case "redis":
+		rdb, err := clients.GetRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return broker.NewRedisStreamsBroker(rdb, utils.GetEnvDuration("REDIS_CLAIM_AFTER", 10*time.Minute)), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported MESSAGE_BROKER: %s", kind)
+	}
+}
+
+// connectTriviaGradeCache returns the cache selected by TRIVIA_GRADE_CACHE,
+// which is one of postgres (the default), redis or none.
+func connectTriviaGradeCache(pgPool *pgxpool.Pool) (repository.TriviaGradeCache, error) {
+	switch kind := utils.GetEnv("TRIVIA_GRADE_CACHE", "postgres"); kind {
+	case "postgres":
+		return repository.NewPostgresTriviaGradeCache(pgPool), nil
+
+	case "redis":
+		// Redis doesn't store embeddings, so every answer would be embedded
+		// for lookups that can't match.
+		if utils.GetEnvFloat("TRIVIA_GRADE_CACHE_SIMILARITY", 0) > 0 {
+			return nil, errors.New("TRIVIA_GRADE_CACHE_SIMILARITY needs the postgres grade cache")
+		}
+		rdb, err := clients.GetRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewRedisTriviaGradeCache(rdb, utils.GetEnvDuration("TRIVIA_GRADE_CACHE_TTL", 30*24*time.Hour)), nil
+
+	case "none":
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported TRIVIA_GRADE_CACHE: %s", kind)
+	}
+}
+
+// migrate applies the pending SQL migrations.
+func migrate() {
+	pgPool, err := clients.GetPostgresPool()
+	failOnError(err, "Unable to connect to PostgreSQL")
+	defer clients.CleanupDbConnections()
+
+	err = migrations.Apply(context.Background(), pgPool)
+	failOnError(err, "Failed to apply migrations")
+}
+
+// parseDate accepts RFC 3339 timestamps and YYYY-MM-DD dates, in UTC.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// rejudge judges finished submits again and writes a CSV report of the
+// verdicts that changed and the submissions that couldn't be judged.
+func rejudge(args []string) {
+	flags := flag.NewFlagSet("rejudge", flag.ExitOnError)
+	problemId := flags.Int("problem", 0, "problem ID")
+	roomId := flags.String("room", "", "room ID")
+	language := flags.String("language", "", "language")
+	since := flags.String("since", "", "only submissions created at or after this date (YYYY-MM-DD or RFC 3339); submissions stored before migration 0005 are dated by their last claim or the migration")
+	until := flags.String("until", "", "only submissions created before this date (YYYY-MM-DD or RFC 3339)")
+	version := flags.Int("version", 0, "problem version to judge against, 0 for the current one")
+	concurrency := flags.Int("concurrency", 4, "submissions judged at the same time")
+	enqueue := flags.Bool("enqueue", false, "hand the submissions to the running workers instead of executing them here")
+	wait := flags.Duration("wait", 10*time.Minute, "how long to wait for each enqueued submission")
+	reportPath := flags.String("report", "-", "file to write the CSV report to, - for stdout")
+	flags.Parse(args)
+
+	filter := repository.SubmissionFilter{ProblemId: *problemId, RoomId: *roomId, Language: *language}
+
+	var err error
+	filter.Since, err = parseDate(*since)
+	failOnError(err, "Invalid -since")
+	filter.Until, err = parseDate(*until)
+	failOnError(err, "Invalid -until")
+
+	if filter.ProblemId == 0 && filter.RoomId == "" && filter.Language == "" && filter.Since.IsZero() && filter.Until.IsZero() {
+		log.Fatal("Select submissions with at least one of -problem, -room, -language, -since or -until")
+	}
+
+	report := os.Stdout
+	if *reportPath != "-" {
+		report, err = os.Create(*reportPath)
+		failOnError(err, "Failed to create the report")
+		defer report.Close()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	pgPool, err := clients.GetPostgresPool()
+	failOnError(err, "Unable to connect to PostgreSQL")
+
+	mongoClient, err := clients.GetMongoClient()
+	failOnError(err, "MongoDB connection error")
+	defer clients.CleanupDbConnections()
+
+	deps := &workers.CompilationDeps{
+		Submissions: repository.NewPostgresSubmissionRepository(pgPool),
+		Problems:    repository.NewMongoProblemRepository(mongoClient),
+	}
+
+	if *enqueue {
+		deps.Broker, err = connectBroker()
+		failOnError(err, "Error connecting to the message broker")
+		defer clients.CleanupMessageQueueConnections()
+		defer deps.Broker.Close()
+	}
+
+	outcomes, err := workers.Rejudge(ctx, deps, workers.RejudgeOptions{
+		Filter:      filter,
+		Version:     *version,
+		Concurrency: *concurrency,
+		Enqueue:     *enqueue,
+		Wait:        *wait,
+	})
+	if err != nil {
+		log.Printf("Rejudge stopped: %v", err)
+	}
+
+	writer := csv.NewWriter(report)
+	writer.Write([]string{"submission_id", "username", "room_id", "language", "old_verdict", "new_verdict", "old_version", "new_version", "error"})
+
+	changed, failed := 0, 0
+	for _, outcome := range outcomes {
+		errorMessage := ""
+		switch {
+		case outcome.Err != nil:
+			failed++
+			errorMessage = outcome.Err.Error()
+		case outcome.Changed():
+			changed++
+		default:
+			continue
+		}
+
+		writer.Write([]string{
+			outcome.SubmissionId,
+			outcome.Username,
+			outcome.RoomId,
+			outcome.Language,
+			outcome.OldVerdict,
+			outcome.NewVerdict,
+			strconv.Itoa(outcome.OldVersion),
+			strconv.Itoa(outcome.NewVersion),
+			errorMessage,
+		})
+	}
+	writer.Flush()
+	failOnError(writer.Error(), "Failed to write the report")
+
+	log.Printf("Rejudged %d submissions: %d changed, %d failed", len(outcomes), changed, failed)
+}
+
+// dev runs the workers with submissions and problems in JSON files, an
+// in-process broker and an HTTP endpoint to submit code, so no external
+// services are needed.
+func dev(args []string) {
+	flags := flag.NewFlagSet("dev", flag.ExitOnError)
+	dataDir := flags.String("data", "dev-data", "directory with problems/<id>.json and submissions.json")
+	addr := flags.String("addr", "localhost:8080", "address of the HTTP endpoint")
+	numWorkers := flags.Int("workers", 2, "number of compilation workers")
+	timeout := flags.Duration("timeout", 2*time.Minute, "how long POST /submissions waits for the result")
+	flags.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	submissions, err := repository.NewFileSubmissionRepository(filepath.Join(*dataDir, "submissions.json"))
+	failOnError(err, "Failed to load submissions")
+
+	b := broker.NewMemoryBroker()
+	defer b.Close()
+
+	deps := &workers.CompilationDeps{
+		Broker:      b,
+		Submissions: submissions,
+		Problems:    repository.NewFileProblemRepository(filepath.Join(*dataDir, "problems")),
+	}
+
+	err = workers.StartCompilationWorkers(ctx, deps, *numWorkers)
+	failOnError(err, "Failed to start compilation workers")
+
+	triviaQuestions, err := repository.LoadTriviaQuestions(filepath.Join(*dataDir, "trivia_questions.json"))
+	failOnError(err, "Failed to load trivia questions")
+
+	promptTemplates, err := repository.LoadPromptTemplates(filepath.Join(*dataDir, "prompts"))
+	failOnError(err, "Failed to load prompt templates")
+
+	// The pipelines share one grader, so the LLM rate limits apply to the
+	// provider rather than to each pipeline. It grades without a model
+	// unless LLM_PROVIDER asks for one.
+	llmConfig := workers.LoadLLMConfig()
+	llmConfig.Provider = utils.GetEnv("LLM_PROVIDER", "fake")
+	grader, err := workers.NewLLMGrader(llmConfig)
+	failOnError(err, "Failed to create the LLM grader")
+
+	triviaDeps := &workers.TriviaDeps{
+		Broker:          b,
+		Questions:       triviaQuestions,
+		Submissions:     repository.NewMemoryTriviaSubmissionRepository(),
+		PromptTemplates: promptTemplates,
+		Grader:          grader,
+	}
+	if utils.GetEnv("TRIVIA_GRADE_CACHE", "memory") != "none" {
+		triviaDeps.GradeCache = repository.NewMemoryTriviaGradeCache()
+	}
+
+	err = workers.StartTriviaWorkers(ctx, triviaDeps, utils.GetEnvInt("TRIVIA_WORKERS", 8))
+	failOnError(err, "Failed to start trivia workers")
+
+	responseQueues := []string{workers.CompilationResponsesQueue, workers.TriviaResponsesQueue}
+	if utils.GetEnvBool("HINTS_ENABLED", false) {
+		hintDeps := &workers.HintDeps{
+			Broker:   b,
+			Problems: deps.Problems,
+			Levels:   repository.NewMemoryHintLevelRepository(),
+			Hinter:   grader,
+		}
+		err = workers.StartHintWorkers(ctx, hintDeps, 1)
+		failOnError(err, "Failed to start hint workers")
+		responseQueues = append(responseQueues, workers.HintResponsesQueue)
+	}
+	if utils.GetEnvBool("CODE_REVIEW_ENABLED", false) {
+		err = workers.StartCodeReviewWorkers(ctx, &workers.CodeReviewDeps{Broker: b, Reviewer: grader}, 1)
+		failOnError(err, "Failed to start code review workers")
+		responseQueues = append(responseQueues, workers.CodeReviewsQueue)
+	}
+
+	server := devserver.NewServer(b, submissions, *timeout)
+	for _, queue := range responseQueues {
+		err = server.LogResponses(ctx, queue)
+		failOnError(err, "Failed to consume responses")
+	}
+
+	httpServer := &http.Server{Addr: *addr, Handler: server.Handler()}
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+
+	log.Printf("Submit code with POST http://%s/submissions. Exit with CTRL + C", *addr)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		failOnError(err, "HTTP server failed")
+	}
+}
+
 func main() {
-	err := godotenv.Load()
-	failOnError(err, "Failed to load .env")
-
-	conn, err := clients.GetRabbitMQConnection()
-	failOnError(err, "Error connecting to RabbitMQ")
-	defer conn.Close()
-
-	ch, err := conn.Channel()
-	failOnError(err, "Failed to create a channel")
-	defer ch.Close()
-
-	compilationQueue, err := ch.QueueDeclare(
-		"compilation_requests",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	failOnError(err, "Failed to declare compilation_requests queue")
-
-	triviaQueue, err := ch.QueueDeclare(
-		"trivia_submissions",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	failOnError(err, "Failed to declare trivia_submissions queue")
-
-	compilationMsgs, err := ch.Consume(
-		compilationQueue.Name,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	failOnError(err, "Failed to register a consumer for compilation_requests")
-
-	triviaMsgs, err := ch.Consume(
-		triviaQueue.Name,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	failOnError(err, "Failed to register a consumer for trivia_submissions")
+	// The environment may be configured without a .env file.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		failOnError(err, "Failed to load .env")
+	}
+
+	command := "work"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "work":
+		work()
+	case "migrate":
+		migrate()
+	case "rejudge":
+		rejudge(os.Args[2:])
+	case "dev":
+		dev(os.Args[2:])
+	default:
+		log.Fatalf("Unknown command %q, expected work, migrate, rejudge or dev", command)
+	}
+}
+
+// work runs the workers until SIGINT or SIGTERM.
+func work() {
+	b, err := connectBroker()
+	failOnError(err, "Error connecting to the message broker")
+	defer clients.CleanupMessageQueueConnections()
+	defer b.Close()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	pgPool, err := clients.GetPostgresPool()
+	failOnError(err, "Unable to connect to PostgreSQL")
+
+	if utils.GetEnvBool("MIGRATE_ON_START", false) {
+		err = migrations.Apply(ctx, pgPool)
+		failOnError(err, "Failed to apply migrations")
+	}
+
+	mongoClient, err := clients.GetMongoClient()
+	failOnError(err, "MongoDB connection error")
+
+	// expvar serves the metrics on /debug/vars of the default mux.
+	if addr := utils.GetEnv("METRICS_ADDR", ""); addr != "" {
+		go func() {
+			log.Printf("Serving metrics on http://%s/debug/vars", addr)
+			if err := http.ListenAndServe(addr, nil); err != nil {
+				log.Printf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
+
+	compilationDeps := &workers.CompilationDeps{
+		Broker:      b,
+		Submissions: repository.NewPostgresSubmissionRepository(pgPool),
+		Problems:    repository.NewMongoProblemRepository(mongoClient),
+	}
+
 	numCompilationRequestWorkers := 5
-	for i := 0; i < numCompilationRequestWorkers; i++ {
-		go workers.SpawnCompilationWorker(i, compilationMsgs)
+	err = workers.StartCompilationWorkers(ctx, compilationDeps, numCompilationRequestWorkers)
+	failOnError(err, "Failed to start compilation workers")
+
+	gradeCache, err := connectTriviaGradeCache(pgPool)
+	failOnError(err, "Failed to connect to the trivia grade cache")
+
+	// The pipelines share one grader, so the LLM rate limits apply to the
+	// provider rather than to each pipeline.
+	grader, err := workers.NewLLMGrader(workers.LoadLLMConfig())
+	failOnError(err, "Failed to create the LLM grader")
+
+	triviaDeps := &workers.TriviaDeps{
+		Broker:          b,
+		Questions:       repository.NewMongoTriviaQuestionRepository(mongoClient),
+		Submissions:     repository.NewPostgresTriviaSubmissionRepository(pgPool),
+		PromptTemplates: repository.NewMongoPromptTemplateRepository(mongoClient),
+		GradeCache:      gradeCache,
+		Grader:          grader,
+	}
+
+	numTriviaWorkers := utils.GetEnvInt("TRIVIA_WORKERS", 8)
+	err = workers.StartTriviaWorkers(ctx, triviaDeps, numTriviaWorkers)
+	failOnError(err, "Failed to start trivia workers")
+
+	if utils.GetEnvBool("HINTS_ENABLED", false) {
+		hintDeps := &workers.HintDeps{
+			Broker:   b,
+			Problems: compilationDeps.Problems,
+			Levels:   repository.NewPostgresHintLevelRepository(pgPool),
+			Hinter:   grader,
+		}
+
+		numHintWorkers := 1
+		err = workers.StartHintWorkers(ctx, hintDeps, numHintWorkers)
+		failOnError(err, "Failed to start hint workers")
 	}
 
-	numTriviaWorkers := 1
-	for i := 0; i < numTriviaWorkers; i++ {
-		go workers.SpawnTriviaWorker(i, triviaMsgs)
+	if utils.GetEnvBool("CODE_REVIEW_ENABLED", false) {
+		numCodeReviewWorkers := 1
+		err = workers.StartCodeReviewWorkers(ctx, &workers.CodeReviewDeps{Broker: b, Reviewer: grader}, numCodeReviewWorkers)
+		failOnError(err, "Failed to start code review workers")
 	}
 
 	log.Println("Workers are running. Exit with CTRL + C")
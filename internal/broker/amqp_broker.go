@@ -0,0 +1,276 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	ampq "github.com/rabbitmq/amqp091-go"
+)
+
+type amqpDelivery struct {
+	delivery ampq.Delivery
+}
+
+func (d amqpDelivery) Message() Message {
+	return Message{
+		Body:          d.delivery.Body,
+		ContentType:   d.delivery.ContentType,
+		Priority:      d.delivery.Priority,
+		ReplyTo:       d.delivery.ReplyTo,
+		CorrelationId: d.delivery.CorrelationId,
+		Headers:       d.delivery.Headers,
+	}
+}
+
+func (d amqpDelivery) Redelivered() bool {
+	return d.delivery.Redelivered
+}
+
+func (d amqpDelivery) Ack() error {
+	return d.delivery.Ack(false)
+}
+
+func (d amqpDelivery) Nack(requeue bool) error {
+	return d.delivery.Nack(false, requeue)
+}
+
+// topicDelivery wraps deliveries from auto-acked topic subscriptions.
+type topicDelivery struct {
+	amqpDelivery
+}
+
+func (d topicDelivery) Ack() error {
+	return nil
+}
+
+func (d topicDelivery) Nack(requeue bool) error {
+	return nil
+}
+
+type AMQPBroker struct {
+	conn *ampq.Connection
+
+	mu        sync.Mutex
+	publishCh *ampq.Channel
+	channels  []*ampq.Channel
+}
+
+func NewAMQPBroker(conn *ampq.Connection) *AMQPBroker {
+	return &AMQPBroker{conn: conn}
+}
+
+func (b *AMQPBroker) openChannel() (*ampq.Channel, error) {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a RabbitMQ channel: %w", err)
+	}
+
+	b.mu.Lock()
+	b.channels = append(b.channels, ch)
+	b.mu.Unlock()
+
+	return ch, nil
+}
+
+func (b *AMQPBroker) publishChannel() (*ampq.Channel, error) {
+	b.mu.Lock()
+	ch := b.publishCh
+	b.mu.Unlock()
+
+	if ch != nil && !ch.IsClosed() {
+		return ch, nil
+	}
+
+	ch, err := b.openChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.publishCh = ch
+	b.mu.Unlock()
+
+	return ch, nil
+}
+
+func queueArgs(options QueueOptions) ampq.Table {
+	if options.MaxPriority <= 0 {
+		return nil
+	}
+	return ampq.Table{"x-max-priority": options.MaxPriority}
+}
+
+func (b *AMQPBroker) DeclareQueue(name string, options QueueOptions) error {
+	ch, err := b.publishChannel()
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
+		name,               // queue name
+		true,               // durable
+		false,              // delete when unused
+		false,              // exclusive
+		false,              // no-wait
+		queueArgs(options), // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (b *AMQPBroker) Consume(ctx context.Context, queue string, options ConsumeOptions) (<-chan Delivery, error) {
+	ch, err := b.openChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Prefetch > 0 {
+		if err := ch.Qos(options.Prefetch, 0, false); err != nil {
+			return nil, fmt.Errorf("failed to set prefetch for %s: %w", queue, err)
+		}
+	}
+
+	msgs, err := ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register a consumer for %s: %w", queue, err)
+	}
+
+	return forward(ctx, ch, msgs, func(d ampq.Delivery) Delivery { return amqpDelivery{d} }), nil
+}
+
+func (b *AMQPBroker) Publish(ctx context.Context, queue string, message Message) error {
+	return b.publish(ctx, "", queue, message)
+}
+
+func (b *AMQPBroker) publish(ctx context.Context, exchange string, key string, message Message) error {
+	ch, err := b.publishChannel()
+	if err != nil {
+		return err
+	}
+
+	contentType := message.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	err = ch.PublishWithContext(
+		ctx,
+		exchange, // exchange
+		key,      // routing key
+		false,    // mandatory
+		false,    // immediate
+		ampq.Publishing{
+			ContentType:   contentType,
+			DeliveryMode:  ampq.Persistent,
+			Priority:      message.Priority,
+			ReplyTo:       message.ReplyTo,
+			CorrelationId: message.CorrelationId,
+			Headers:       message.Headers,
+			Body:          message.Body,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", exchange+key, err)
+	}
+
+	return nil
+}
+
+func (b *AMQPBroker) declareTopic(ch *ampq.Channel, topic string) error {
+	err := ch.ExchangeDeclare(topic, "fanout", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", topic, err)
+	}
+	return nil
+}
+
+func (b *AMQPBroker) Subscribe(ctx context.Context, topic string) (<-chan Delivery, error) {
+	ch, err := b.openChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.declareTopic(ch, topic); err != nil {
+		return nil, err
+	}
+
+	// An exclusive, server named queue per subscriber, so every process sees
+	// every message.
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to declare a queue for %s: %w", topic, err)
+	}
+
+	if err := ch.QueueBind(queue.Name, "", topic, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to bind a queue to %s: %w", topic, err)
+	}
+
+	msgs, err := ch.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register a consumer for %s: %w", topic, err)
+	}
+
+	return forward(ctx, ch, msgs, func(d ampq.Delivery) Delivery { return topicDelivery{amqpDelivery{d}} }), nil
+}
+
+func (b *AMQPBroker) Broadcast(ctx context.Context, topic string, message Message) error {
+	ch, err := b.publishChannel()
+	if err != nil {
+		return err
+	}
+
+	if err := b.declareTopic(ch, topic); err != nil {
+		return err
+	}
+
+	return b.publish(ctx, topic, "", message)
+}
+
+func (b *AMQPBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.channels {
+		if !ch.IsClosed() {
+			ch.Close()
+		}
+	}
+	b.channels = nil
+	b.publishCh = nil
+
+	return nil
+}
+
+// forward converts AMQP deliveries until the source closes or ctx is done,
+// then closes the consuming channel.
+func forward(ctx context.Context, ch *ampq.Channel, msgs <-chan ampq.Delivery, wrap func(ampq.Delivery) Delivery) <-chan Delivery {
+	out := make(chan Delivery)
+
+	go func() {
+		defer close(out)
+		defer ch.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case out <- wrap(msg):
+				case <-ctx.Done():
+					msg.Nack(false, true)
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,46 @@
+package broker
+
+import "context"
+
+// Message is what gets published to and consumed from a queue or topic.
+type Message struct {
+	Body          []byte
+	ContentType   string
+	Priority      uint8
+	ReplyTo       string
+	CorrelationId string
+	Headers       map[string]interface{}
+}
+
+// Delivery is a consumed message. Every delivery from a queue must be acked
+// or nacked exactly once; deliveries from a topic need neither.
+type Delivery interface {
+	Message() Message
+	Redelivered() bool
+	Ack() error
+	Nack(requeue bool) error
+}
+
+type QueueOptions struct {
+	// MaxPriority enables message priorities from 0 to MaxPriority.
+	MaxPriority int
+}
+
+type ConsumeOptions struct {
+	// Prefetch limits the number of unacked deliveries, 0 means no limit.
+	Prefetch int
+}
+
+// Broker is the message broker the workers consume from and publish to.
+//
+// Queues deliver each message to one consumer. Topics deliver each message to
+// every subscriber, and are used for control messages every worker process
+// needs to see.
+type Broker interface {
+	DeclareQueue(name string, options QueueOptions) error
+	Consume(ctx context.Context, queue string, options ConsumeOptions) (<-chan Delivery, error)
+	Publish(ctx context.Context, queue string, message Message) error
+	Subscribe(ctx context.Context, topic string) (<-chan Delivery, error)
+	Broadcast(ctx context.Context, topic string, message Message) error
+	Close() error
+}
@@ -0,0 +1,291 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+// newJetStreamBroker runs an embedded NATS server with JetStream.
+func newJetStreamBroker(t *testing.T) *JetStreamBroker {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("NATS server didn't start")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+
+	b, err := NewJetStreamBroker(nc, time.Second)
+	if err != nil {
+		t.Fatalf("NewJetStreamBroker: %v", err)
+	}
+	return b
+}
+
+// newRedisClient connects to the Redis server at REDIS_TEST_URL, whose
+// database is flushed, or to an in-process Redis when it is unset.
+func newRedisClient(t *testing.T) *redis.Client {
+	t.Helper()
+
+	url := os.Getenv("REDIS_TEST_URL")
+	if url == "" {
+		url = "redis://" + miniredis.RunT(t).Addr()
+	}
+
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatalf("invalid REDIS_TEST_URL: %v", err)
+	}
+
+	rdb := redis.NewClient(options)
+	if err := rdb.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("failed to flush Redis: %v", err)
+	}
+	return rdb
+}
+
+func receive(t *testing.T, msgs <-chan Delivery) Delivery {
+	t.Helper()
+
+	select {
+	case msg, ok := <-msgs:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return msg
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+		return nil
+	}
+}
+
+// testBroker checks the behavior the workers rely on from every broker.
+func testBroker(t *testing.T, b Broker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("ack", func(t *testing.T) {
+		if err := b.DeclareQueue("ack", QueueOptions{}); err != nil {
+			t.Fatalf("DeclareQueue: %v", err)
+		}
+		msgs, err := b.Consume(ctx, "ack", ConsumeOptions{})
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+
+		sent := Message{
+			Body:          []byte(`{"submissionId":"s1"}`),
+			ContentType:   "application/json",
+			ReplyTo:       "replies",
+			CorrelationId: "c1",
+		}
+		if err := b.Publish(ctx, "ack", sent); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+
+		msg := receive(t, msgs)
+		got := msg.Message()
+		if string(got.Body) != string(sent.Body) || got.ContentType != sent.ContentType || got.ReplyTo != sent.ReplyTo || got.CorrelationId != sent.CorrelationId {
+			t.Errorf("message = %+v, want %+v", got, sent)
+		}
+		if msg.Redelivered() {
+			t.Error("first delivery is redelivered")
+		}
+		if err := msg.Ack(); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	})
+
+	t.Run("nack requeues", func(t *testing.T) {
+		if err := b.DeclareQueue("nack", QueueOptions{}); err != nil {
+			t.Fatalf("DeclareQueue: %v", err)
+		}
+		msgs, err := b.Consume(ctx, "nack", ConsumeOptions{})
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+
+		if err := b.Publish(ctx, "nack", Message{Body: []byte("retry")}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+
+		if err := receive(t, msgs).Nack(true); err != nil {
+			t.Fatalf("Nack: %v", err)
+		}
+
+		msg := receive(t, msgs)
+		if string(msg.Message().Body) != "retry" || !msg.Redelivered() {
+			t.Errorf("requeued delivery = %q, redelivered %v, want a redelivered retry", msg.Message().Body, msg.Redelivered())
+		}
+		msg.Ack()
+	})
+
+	t.Run("broadcast", func(t *testing.T) {
+		subscribers := make([]<-chan Delivery, 2)
+		for i := range subscribers {
+			msgs, err := b.Subscribe(ctx, "control")
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+			subscribers[i] = msgs
+		}
+
+		body := `{"submissionId":"s1","socketId":"socket-1"}`
+		if err := b.Broadcast(ctx, "control", Message{Body: []byte(body)}); err != nil {
+			t.Fatalf("Broadcast: %v", err)
+		}
+
+		for i, msgs := range subscribers {
+			if got := string(receive(t, msgs).Message().Body); got != body {
+				t.Errorf("subscriber %d got %q, want %q", i, got, body)
+			}
+		}
+	})
+}
+
+func TestMemoryBroker(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+
+	testBroker(t, b)
+}
+
+func TestMemoryBrokerSlowSubscriber(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+
+	ctx, unsubscribe := context.WithCancel(context.Background())
+	if _, err := b.Subscribe(ctx, "control"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// Fill the subscriber's buffer, so the next broadcast waits for it.
+	for range 64 {
+		if err := b.Broadcast(context.Background(), "control", Message{Body: []byte("queued")}); err != nil {
+			t.Fatalf("Broadcast: %v", err)
+		}
+	}
+	broadcast := make(chan error, 1)
+	go func() {
+		broadcast <- b.Broadcast(context.Background(), "control", Message{Body: []byte("blocked")})
+	}()
+
+	// Other subscribers don't wait for the blocked broadcast.
+	subscribed := make(chan error, 1)
+	go func() {
+		_, err := b.Subscribe(context.Background(), "other")
+		subscribed <- err
+	}()
+	select {
+	case err := <-subscribed:
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe waited for a blocked broadcast")
+	}
+
+	// Unsubscribing releases the broadcast instead of sending on a closed
+	// channel.
+	unsubscribe()
+	select {
+	case err := <-broadcast:
+		if err != nil {
+			t.Errorf("Broadcast = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Broadcast is still blocked after unsubscribing")
+	}
+}
+
+func TestJetStreamBroker(t *testing.T) {
+	b := newJetStreamBroker(t)
+	defer b.Close()
+
+	testBroker(t, b)
+}
+
+func TestRedisStreamsBroker(t *testing.T) {
+	b := NewRedisStreamsBroker(newRedisClient(t), time.Minute)
+	defer b.Close()
+
+	testBroker(t, b)
+}
+
+// External publishers, like the API server, publish control messages as the
+// plain JSON body.
+func TestRedisStreamsBrokerSubscribeRawPayload(t *testing.T) {
+	rdb := newRedisClient(t)
+	b := NewRedisStreamsBroker(rdb, time.Minute)
+	defer b.Close()
+
+	ctx := context.Background()
+	msgs, err := b.Subscribe(ctx, "compilation_control")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	body := `{"submissionId":"s1"}`
+	if err := rdb.Publish(ctx, redisTopicChannelPrefix+"compilation_control", body).Err(); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	if got := string(receive(t, msgs).Message().Body); got != body {
+		t.Errorf("body = %q, want %q", got, body)
+	}
+}
+
+func TestRedisStreamsBrokerClose(t *testing.T) {
+	b := NewRedisStreamsBroker(newRedisClient(t), time.Minute)
+
+	ctx := context.Background()
+	if err := b.DeclareQueue("close", QueueOptions{}); err != nil {
+		t.Fatalf("DeclareQueue: %v", err)
+	}
+	msgs, err := b.Consume(ctx, "close", ConsumeOptions{})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	topic, err := b.Subscribe(ctx, "control")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- b.Close() }()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close didn't stop the consumers")
+	}
+
+	for _, deliveries := range []<-chan Delivery{msgs, topic} {
+		if _, ok := <-deliveries; ok {
+			t.Error("deliveries are still open after Close")
+		}
+	}
+
+	if _, err := b.Consume(ctx, "close", ConsumeOptions{}); err == nil {
+		t.Error("Consume after Close succeeded")
+	}
+}
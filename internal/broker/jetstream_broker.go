@@ -0,0 +1,266 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	natsQueueSubjectPrefix = "octree.queues."
+	natsTopicSubjectPrefix = "octree.topics."
+
+	natsHeaderReplyTo       = "Octree-Reply-To"
+	natsHeaderCorrelationId = "Octree-Correlation-Id"
+	natsHeaderContentType   = "Content-Type"
+	natsHeaderPriority      = "Octree-Priority"
+	natsHeaderHeaders       = "Octree-Headers"
+)
+
+func natsHeader(message Message) nats.Header {
+	header := nats.Header{}
+	if message.ContentType != "" {
+		header.Set(natsHeaderContentType, message.ContentType)
+	}
+	if message.ReplyTo != "" {
+		header.Set(natsHeaderReplyTo, message.ReplyTo)
+	}
+	if message.CorrelationId != "" {
+		header.Set(natsHeaderCorrelationId, message.CorrelationId)
+	}
+	if message.Priority > 0 {
+		header.Set(natsHeaderPriority, strconv.Itoa(int(message.Priority)))
+	}
+	if len(message.Headers) > 0 {
+		if encoded, err := json.Marshal(message.Headers); err == nil {
+			header.Set(natsHeaderHeaders, string(encoded))
+		}
+	}
+	return header
+}
+
+func natsMessage(data []byte, header nats.Header) Message {
+	message := Message{
+		Body:          data,
+		ContentType:   header.Get(natsHeaderContentType),
+		ReplyTo:       header.Get(natsHeaderReplyTo),
+		CorrelationId: header.Get(natsHeaderCorrelationId),
+	}
+	if priority, err := strconv.Atoi(header.Get(natsHeaderPriority)); err == nil {
+		message.Priority = uint8(priority)
+	}
+	if encoded := header.Get(natsHeaderHeaders); encoded != "" {
+		json.Unmarshal([]byte(encoded), &message.Headers)
+	}
+	return message
+}
+
+type jetStreamDelivery struct {
+	msg jetstream.Msg
+}
+
+func (d jetStreamDelivery) Message() Message {
+	return natsMessage(d.msg.Data(), d.msg.Headers())
+}
+
+func (d jetStreamDelivery) Redelivered() bool {
+	metadata, err := d.msg.Metadata()
+	return err == nil && metadata.NumDelivered > 1
+}
+
+func (d jetStreamDelivery) Ack() error {
+	return d.msg.Ack()
+}
+
+func (d jetStreamDelivery) Nack(requeue bool) error {
+	if requeue {
+		return d.msg.Nak()
+	}
+	return d.msg.Term()
+}
+
+type natsTopicDelivery struct {
+	msg *nats.Msg
+}
+
+func (d natsTopicDelivery) Message() Message {
+	return natsMessage(d.msg.Data, d.msg.Header)
+}
+
+func (d natsTopicDelivery) Redelivered() bool {
+	return false
+}
+
+func (d natsTopicDelivery) Ack() error {
+	return nil
+}
+
+func (d natsTopicDelivery) Nack(requeue bool) error {
+	return nil
+}
+
+// JetStreamBroker maps every queue to a work queue stream with one durable
+// consumer shared by all workers, and topics to core NATS subjects.
+//
+// Unacked messages are redelivered after AckWait, so AckWait must be longer
+// than the slowest submission. JetStream has no message priorities; lanes
+// still apply their weights.
+type JetStreamBroker struct {
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	ackWait time.Duration
+}
+
+func NewJetStreamBroker(nc *nats.Conn, ackWait time.Duration) (*JetStreamBroker, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	return &JetStreamBroker{nc: nc, js: js, ackWait: ackWait}, nil
+}
+
+func (b *JetStreamBroker) DeclareQueue(name string, options QueueOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := b.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      name,
+		Subjects:  []string{natsQueueSubjectPrefix + name},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to declare stream %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (b *JetStreamBroker) Consume(ctx context.Context, queue string, options ConsumeOptions) (<-chan Delivery, error) {
+	config := jetstream.ConsumerConfig{
+		Durable:   queue + "-workers",
+		AckPolicy: jetstream.AckExplicitPolicy,
+		AckWait:   b.ackWait,
+	}
+	if options.Prefetch > 0 {
+		config.MaxAckPending = options.Prefetch
+	}
+
+	consumer, err := b.js.CreateOrUpdateConsumer(ctx, queue, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a consumer for %s: %w", queue, err)
+	}
+
+	// Without a limit the iterator buffers up to 500 messages, whose ack
+	// wait runs out while they sit in the buffer, so pull one at a time.
+	pullMax := 1
+	if options.Prefetch > 0 {
+		pullMax = options.Prefetch
+	}
+
+	iter, err := consumer.Messages(jetstream.PullMaxMessages(pullMax))
+	if err != nil {
+		return nil, fmt.Errorf("failed to register a consumer for %s: %w", queue, err)
+	}
+
+	out := make(chan Delivery)
+
+	go func() {
+		<-ctx.Done()
+		iter.Stop()
+	}()
+
+	go func() {
+		defer close(out)
+
+		for {
+			msg, err := iter.Next()
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("JetStream consumer for %s failed: %v", queue, err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			select {
+			case out <- jetStreamDelivery{msg}:
+			case <-ctx.Done():
+				msg.Nak()
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *JetStreamBroker) Publish(ctx context.Context, queue string, message Message) error {
+	_, err := b.js.PublishMsg(ctx, &nats.Msg{
+		Subject: natsQueueSubjectPrefix + queue,
+		Header:  natsHeader(message),
+		Data:    message.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", queue, err)
+	}
+
+	return nil
+}
+
+func (b *JetStreamBroker) Subscribe(ctx context.Context, topic string) (<-chan Delivery, error) {
+	msgs := make(chan *nats.Msg, 64)
+
+	sub, err := b.nc.ChanSubscribe(natsTopicSubjectPrefix+topic, msgs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+
+	out := make(chan Delivery)
+
+	go func() {
+		defer close(out)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-msgs:
+				select {
+				case out <- natsTopicDelivery{msg}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *JetStreamBroker) Broadcast(ctx context.Context, topic string, message Message) error {
+	err := b.nc.PublishMsg(&nats.Msg{
+		Subject: natsTopicSubjectPrefix + topic,
+		Header:  natsHeader(message),
+		Data:    message.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+func (b *JetStreamBroker) Close() error {
+	return b.nc.Drain()
+}
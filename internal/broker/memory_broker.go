@@ -0,0 +1,319 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type memoryEnvelope struct {
+	message     Message
+	redelivered bool
+	seq         uint64
+}
+
+type memoryQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	messages []memoryEnvelope
+	seq      uint64
+}
+
+func newMemoryQueue() *memoryQueue {
+	q := &memoryQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push keeps messages ordered by priority, then by arrival.
+func (q *memoryQueue) push(envelope memoryEnvelope) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if envelope.seq == 0 {
+		q.seq++
+		envelope.seq = q.seq
+	}
+
+	q.messages = append(q.messages, envelope)
+	sort.SliceStable(q.messages, func(i, j int) bool {
+		if q.messages[i].message.Priority != q.messages[j].message.Priority {
+			return q.messages[i].message.Priority > q.messages[j].message.Priority
+		}
+		return q.messages[i].seq < q.messages[j].seq
+	})
+	q.cond.Broadcast()
+}
+
+// pop blocks until a message is available or ctx is done.
+func (q *memoryQueue) pop(ctx context.Context) (memoryEnvelope, bool) {
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	})
+	defer stop()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.messages) == 0 {
+		if ctx.Err() != nil {
+			return memoryEnvelope{}, false
+		}
+		q.cond.Wait()
+	}
+
+	envelope := q.messages[0]
+	q.messages = q.messages[1:]
+	return envelope, true
+}
+
+func (q *memoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+type memoryDelivery struct {
+	queue    *memoryQueue
+	envelope memoryEnvelope
+	release  func()
+
+	mu      sync.Mutex
+	settled bool
+}
+
+func (d *memoryDelivery) Message() Message {
+	return d.envelope.message
+}
+
+func (d *memoryDelivery) Redelivered() bool {
+	return d.envelope.redelivered
+}
+
+func (d *memoryDelivery) settle() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.settled {
+		return fmt.Errorf("delivery already acked or nacked")
+	}
+	d.settled = true
+
+	if d.release != nil {
+		d.release()
+	}
+	return nil
+}
+
+func (d *memoryDelivery) Ack() error {
+	return d.settle()
+}
+
+func (d *memoryDelivery) Nack(requeue bool) error {
+	if err := d.settle(); err != nil {
+		return err
+	}
+
+	if requeue && d.queue != nil {
+		envelope := d.envelope
+		envelope.redelivered = true
+		d.queue.push(envelope)
+	}
+	return nil
+}
+
+// memorySubscriber is a topic subscription. done is closed before ch, so a
+// broadcast blocked on a full ch gives up instead of sending on a closed
+// channel.
+type memorySubscriber struct {
+	ch   chan Delivery
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *memorySubscriber) send(ctx context.Context, delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	select {
+	case s.ch <- delivery:
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (s *memorySubscriber) close() {
+	close(s.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	close(s.ch)
+}
+
+// MemoryBroker is an in-process Broker for tests and local development.
+// Messages are lost when the process exits.
+type MemoryBroker struct {
+	mu          sync.Mutex
+	queues      map[string]*memoryQueue
+	subscribers map[string][]*memorySubscriber
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues:      make(map[string]*memoryQueue),
+		subscribers: make(map[string][]*memorySubscriber),
+		closed:      make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) queue(name string) *memoryQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		q = newMemoryQueue()
+		b.queues[name] = q
+	}
+	return q
+}
+
+func (b *MemoryBroker) DeclareQueue(name string, options QueueOptions) error {
+	b.queue(name)
+	return nil
+}
+
+// Len returns the number of messages waiting in a queue.
+func (b *MemoryBroker) Len(queue string) int {
+	return b.queue(queue).Len()
+}
+
+func (b *MemoryBroker) Consume(ctx context.Context, queue string, options ConsumeOptions) (<-chan Delivery, error) {
+	q := b.queue(queue)
+	out := make(chan Delivery)
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-b.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var inflight chan struct{}
+	if options.Prefetch > 0 {
+		inflight = make(chan struct{}, options.Prefetch)
+	}
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		for {
+			if inflight != nil {
+				select {
+				case inflight <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			envelope, ok := q.pop(ctx)
+			if !ok {
+				return
+			}
+
+			delivery := &memoryDelivery{queue: q, envelope: envelope}
+			if inflight != nil {
+				delivery.release = func() { <-inflight }
+			}
+
+			select {
+			case out <- delivery:
+			case <-ctx.Done():
+				delivery.Nack(true)
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, queue string, message Message) error {
+	select {
+	case <-b.closed:
+		return fmt.Errorf("broker is closed")
+	default:
+	}
+
+	b.queue(queue).push(memoryEnvelope{message: message})
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic string) (<-chan Delivery, error) {
+	subscriber := &memorySubscriber{ch: make(chan Delivery, 64), done: make(chan struct{})}
+
+	b.mu.Lock()
+	b.subscribers[topic] = append(b.subscribers[topic], subscriber)
+	b.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-b.closed:
+		}
+
+		b.mu.Lock()
+		subscribers := b.subscribers[topic]
+		for i := range subscribers {
+			if subscribers[i] == subscriber {
+				b.subscribers[topic] = append(subscribers[:i:i], subscribers[i+1:]...)
+				break
+			}
+		}
+		b.mu.Unlock()
+
+		subscriber.close()
+	}()
+
+	return subscriber.ch, nil
+}
+
+// Broadcast sends message to the current subscribers of topic, waiting for
+// room in their buffers without holding the broker's lock.
+func (b *MemoryBroker) Broadcast(ctx context.Context, topic string, message Message) error {
+	b.mu.Lock()
+	subscribers := b.subscribers[topic]
+	b.mu.Unlock()
+
+	for _, subscriber := range subscribers {
+		if err := subscriber.send(ctx, &memoryDelivery{envelope: memoryEnvelope{message: message}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+	})
+	return nil
+}
@@ -0,0 +1,376 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisQueueKeyPrefix     = "octree:queues:"
+	redisTopicChannelPrefix = "octree:topics:"
+	redisConsumerGroup      = "workers"
+)
+
+func redisValues(message Message, redelivered bool) map[string]interface{} {
+	values := map[string]interface{}{
+		"body":          message.Body,
+		"contentType":   message.ContentType,
+		"priority":      int(message.Priority),
+		"replyTo":       message.ReplyTo,
+		"correlationId": message.CorrelationId,
+		"redelivered":   redelivered,
+	}
+	if len(message.Headers) > 0 {
+		if encoded, err := json.Marshal(message.Headers); err == nil {
+			values["headers"] = string(encoded)
+		}
+	}
+	return values
+}
+
+func redisMessage(values map[string]interface{}) (Message, bool) {
+	field := func(name string) string {
+		value, _ := values[name].(string)
+		return value
+	}
+
+	message := Message{
+		Body:          []byte(field("body")),
+		ContentType:   field("contentType"),
+		ReplyTo:       field("replyTo"),
+		CorrelationId: field("correlationId"),
+	}
+	if priority, err := strconv.Atoi(field("priority")); err == nil {
+		message.Priority = uint8(priority)
+	}
+	if encoded := field("headers"); encoded != "" {
+		json.Unmarshal([]byte(encoded), &message.Headers)
+	}
+
+	redelivered, _ := strconv.ParseBool(field("redelivered"))
+	return message, redelivered
+}
+
+type redisDelivery struct {
+	broker      *RedisStreamsBroker
+	stream      string
+	id          string
+	message     Message
+	redelivered bool
+	release     func()
+	once        sync.Once
+}
+
+func (d *redisDelivery) Message() Message {
+	return d.message
+}
+
+func (d *redisDelivery) Redelivered() bool {
+	return d.redelivered
+}
+
+// remove acks and deletes the entry, so streams behave like work queues and
+// don't grow without bound.
+func (d *redisDelivery) remove(ctx context.Context) error {
+	defer d.once.Do(func() {
+		if d.release != nil {
+			d.release()
+		}
+	})
+
+	pipe := d.broker.rdb.TxPipeline()
+	pipe.XAck(ctx, d.stream, redisConsumerGroup, d.id)
+	pipe.XDel(ctx, d.stream, d.id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (d *redisDelivery) Ack() error {
+	return d.remove(context.Background())
+}
+
+func (d *redisDelivery) Nack(requeue bool) error {
+	ctx := context.Background()
+
+	if requeue {
+		err := d.broker.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: d.stream,
+			Values: redisValues(d.message, true),
+		}).Err()
+		if err != nil {
+			return fmt.Errorf("failed to requeue message: %w", err)
+		}
+	}
+
+	return d.remove(ctx)
+}
+
+type redisTopicDelivery struct {
+	message Message
+}
+
+func (d redisTopicDelivery) Message() Message {
+	return d.message
+}
+
+func (d redisTopicDelivery) Redelivered() bool {
+	return false
+}
+
+func (d redisTopicDelivery) Ack() error {
+	return nil
+}
+
+func (d redisTopicDelivery) Nack(requeue bool) error {
+	return nil
+}
+
+// RedisStreamsBroker maps every queue to a stream read through one consumer
+// group, and topics to Pub/Sub channels.
+//
+// Entries that stay unacked for longer than claimAfter, e.g. because their
+// worker died, are claimed by another consumer and delivered as redelivered.
+// Redis Streams have no message priorities; lanes still apply their weights.
+type RedisStreamsBroker struct {
+	rdb        *redis.Client
+	consumer   string
+	claimAfter time.Duration
+
+	// closed stops the consumers when the broker is closed.
+	closed    context.Context
+	close     context.CancelFunc
+	consumers sync.WaitGroup
+}
+
+func NewRedisStreamsBroker(rdb *redis.Client, claimAfter time.Duration) *RedisStreamsBroker {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	closed, close := context.WithCancel(context.Background())
+	return &RedisStreamsBroker{
+		rdb:        rdb,
+		consumer:   fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8]),
+		claimAfter: claimAfter,
+		closed:     closed,
+		close:      close,
+	}
+}
+
+// consumerContext returns a context that is done when ctx is done or the
+// broker is closed, and counts the consumer until stop is called.
+func (b *RedisStreamsBroker) consumerContext(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	unregister := context.AfterFunc(b.closed, cancel)
+
+	b.consumers.Add(1)
+	return ctx, func() {
+		unregister()
+		cancel()
+		b.consumers.Done()
+	}
+}
+
+func (b *RedisStreamsBroker) DeclareQueue(name string, options QueueOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Start at 0 so messages published before the group existed are consumed.
+	err := b.rdb.XGroupCreateMkStream(ctx, redisQueueKeyPrefix+name, redisConsumerGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to declare stream %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (b *RedisStreamsBroker) Consume(ctx context.Context, queue string, options ConsumeOptions) (<-chan Delivery, error) {
+	if b.closed.Err() != nil {
+		return nil, errors.New("broker is closed")
+	}
+
+	stream := redisQueueKeyPrefix + queue
+	ctx, stop := b.consumerContext(ctx)
+	out := make(chan Delivery)
+
+	var inflight chan struct{}
+	if options.Prefetch > 0 {
+		inflight = make(chan struct{}, options.Prefetch)
+	}
+
+	deliver := func(entry redis.XMessage) bool {
+		message, redelivered := redisMessage(entry.Values)
+		delivery := &redisDelivery{
+			broker:      b,
+			stream:      stream,
+			id:          entry.ID,
+			message:     message,
+			redelivered: redelivered,
+		}
+
+		if inflight != nil {
+			select {
+			case inflight <- struct{}{}:
+				delivery.release = func() { <-inflight }
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		select {
+		case out <- delivery:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer stop()
+		defer close(out)
+
+		lastClaim := time.Now()
+		for ctx.Err() == nil {
+			if time.Since(lastClaim) > b.claimAfter/2 {
+				lastClaim = time.Now()
+
+				claimed, _, err := b.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+					Stream:   stream,
+					Group:    redisConsumerGroup,
+					Consumer: b.consumer,
+					MinIdle:  b.claimAfter,
+					Start:    "0-0",
+					Count:    10,
+				}).Result()
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Failed to claim stale entries from %s: %v", stream, err)
+				}
+
+				for _, entry := range claimed {
+					entry.Values["redelivered"] = "true"
+					if !deliver(entry) {
+						return
+					}
+				}
+			}
+
+			streams, err := b.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    redisConsumerGroup,
+				Consumer: b.consumer,
+				Streams:  []string{stream, ">"},
+				Count:    1,
+				Block:    5 * time.Second,
+			}).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Redis consumer for %s failed: %v", stream, err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+
+			for _, result := range streams {
+				for _, entry := range result.Messages {
+					if !deliver(entry) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *RedisStreamsBroker) Publish(ctx context.Context, queue string, message Message) error {
+	err := b.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisQueueKeyPrefix + queue,
+		Values: redisValues(message, false),
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", queue, err)
+	}
+
+	return nil
+}
+
+// Subscribe delivers the raw payloads published to a topic's channel, so
+// other publishers don't need to know the broker's encoding. Pub/Sub payloads
+// have no properties, so only the Body is set.
+func (b *RedisStreamsBroker) Subscribe(ctx context.Context, topic string) (<-chan Delivery, error) {
+	if b.closed.Err() != nil {
+		return nil, errors.New("broker is closed")
+	}
+
+	pubsub := b.rdb.Subscribe(ctx, redisTopicChannelPrefix+topic)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+
+	ctx, stop := b.consumerContext(ctx)
+	out := make(chan Delivery)
+
+	go func() {
+		defer stop()
+		defer close(out)
+		defer pubsub.Close()
+
+		msgs := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+
+				select {
+				case out <- redisTopicDelivery{Message{Body: []byte(msg.Payload)}}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Broadcast publishes the message's Body to the topic's channel.
+func (b *RedisStreamsBroker) Broadcast(ctx context.Context, topic string, message Message) error {
+	if err := b.rdb.Publish(ctx, redisTopicChannelPrefix+topic, message.Body).Err(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+// Close stops the consumers and subscribers and closes the client, which
+// also interrupts blocked stream reads.
+func (b *RedisStreamsBroker) Close() error {
+	b.close()
+	err := b.rdb.Close()
+	b.consumers.Wait()
+
+	if errors.Is(err, redis.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -1,17 +1,28 @@
 package clients
 
 import (
+	"context"
 	"log"
 	"os"
 	"sync"
 
+	"github.com/nats-io/nats.go"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
 )
 
 var (
 	rabbitOnce sync.Once
 	rabbitConn *amqp.Connection
 	rabbitErr  error
+
+	natsOnce sync.Once
+	natsConn *nats.Conn
+	natsErr  error
+
+	redisOnce   sync.Once
+	redisClient *redis.Client
+	redisErr    error
 )
 
 func GetRabbitMQConnection() (*amqp.Connection, error) {
@@ -24,3 +35,47 @@ func GetRabbitMQConnection() (*amqp.Connection, error) {
 	})
 	return rabbitConn, rabbitErr
 }
+
+func GetNatsConnection() (*nats.Conn, error) {
+	natsOnce.Do(func() {
+		natsConn, natsErr = nats.Connect(os.Getenv("NATS_URL"), nats.Name("octree.io-worker"))
+		if natsErr != nil {
+			log.Fatalf("Failed to connect to NATS: %v", natsErr)
+		}
+		log.Println("Connected to NATS")
+	})
+	return natsConn, natsErr
+}
+
+func GetRedisClient() (*redis.Client, error) {
+	redisOnce.Do(func() {
+		var options *redis.Options
+		options, redisErr = redis.ParseURL(os.Getenv("REDIS_URL"))
+		if redisErr != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", redisErr)
+		}
+
+		redisClient = redis.NewClient(options)
+		redisErr = redisClient.Ping(context.Background()).Err()
+		if redisErr != nil {
+			log.Fatalf("Failed to connect to Redis: %v", redisErr)
+		}
+		log.Println("Connected to Redis")
+	})
+	return redisClient, redisErr
+}
+
+func CleanupMessageQueueConnections() {
+	if rabbitConn != nil && !rabbitConn.IsClosed() {
+		rabbitConn.Close()
+		log.Println("RabbitMQ connection closed.")
+	}
+	if natsConn != nil && !natsConn.IsClosed() {
+		natsConn.Close()
+		log.Println("NATS connection closed.")
+	}
+	if redisClient != nil {
+		redisClient.Close()
+		log.Println("Redis connection closed.")
+	}
+}
@@ -0,0 +1,163 @@
+package devserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+	"octree.io-worker/internal/workers"
+)
+
+type submitRequest struct {
+	ProblemId int    `json:"problemId"`
+	Language  string `json:"language"`
+	Code      string `json:"code"`
+	Type      string `json:"type"`
+	RoomId    string `json:"roomId"`
+	Username  string `json:"username"`
+}
+
+type submissionResponse struct {
+	SubmissionId   string                   `json:"submissionId"`
+	ProblemId      int                      `json:"problemId"`
+	Language       string                   `json:"language"`
+	Type           string                   `json:"type"`
+	Status         string                   `json:"status"`
+	ProblemVersion int                      `json:"problemVersion,omitempty"`
+	Result         *models.SubmissionResult `json:"result,omitempty"`
+}
+
+func newSubmissionResponse(submission *repository.Submission) submissionResponse {
+	return submissionResponse{
+		SubmissionId:   submission.SubmissionId,
+		ProblemId:      submission.ProblemId,
+		Language:       submission.Language,
+		Type:           submission.RunType,
+		Status:         submission.Status,
+		ProblemVersion: submission.ProblemVersion,
+		Result:         submission.Result,
+	}
+}
+
+// Server stores submissions posted over HTTP and queues them for the
+// in-process compilation workers, like the octree.io backend does.
+type Server struct {
+	broker      broker.Broker
+	submissions *repository.FileSubmissionRepository
+	timeout     time.Duration
+}
+
+func NewServer(b broker.Broker, submissions *repository.FileSubmissionRepository, timeout time.Duration) *Server {
+	return &Server{broker: b, submissions: submissions, timeout: timeout}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /submissions", s.submit)
+	mux.HandleFunc("GET /submissions/{id}", s.get)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// submit judges a submission and responds with its result, or with 202 and
+// the pending submission when it takes longer than the timeout.
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	var request submitRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if request.ProblemId == 0 || request.Language == "" || request.Code == "" {
+		writeError(w, http.StatusBadRequest, "problemId, language and code are required")
+		return
+	}
+	if request.Type == "" {
+		request.Type = "run"
+	}
+
+	submission := repository.Submission{
+		SubmissionId: uuid.New().String(),
+		ProblemId:    request.ProblemId,
+		Language:     request.Language,
+		Code:         request.Code,
+		RunType:      request.Type,
+		RoomId:       request.RoomId,
+		Username:     request.Username,
+	}
+	if err := s.submissions.Put(submission); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err := workers.PublishCompilationRequest(r.Context(), s.broker, workers.CompilationRequestMessage{SubmissionId: submission.SubmissionId})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	judged, err := workers.WaitForSubmission(ctx, s.submissions, submission.SubmissionId)
+	if err != nil {
+		pending, _ := s.submissions.Get(r.Context(), submission.SubmissionId)
+		if pending == nil {
+			pending = &submission
+		}
+		writeJSON(w, http.StatusAccepted, newSubmissionResponse(pending))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newSubmissionResponse(judged))
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	submission, err := s.submissions.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "submission not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newSubmissionResponse(submission))
+}
+
+// LogResponses logs the messages the workers publish for clients, which
+// nothing else consumes in development.
+func (s *Server) LogResponses(ctx context.Context, queue string) error {
+	msgs, err := s.broker.Consume(ctx, queue, broker.ConsumeOptions{})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range msgs {
+			log.Printf("[%s] %s", queue, msg.Message().Body)
+			msg.Ack()
+		}
+	}()
+
+	return nil
+}
@@ -7,6 +7,13 @@ import (
 	"octree.io-worker/internal/utils"
 )
 
+type TestCaseVerdict struct {
+	Index    int
+	Passed   bool
+	Expected string
+	Actual   string
+}
+
 func JudgeTestCases(
 	outputs []map[string]interface{},
 	stdout string,
@@ -14,31 +21,69 @@ func JudgeTestCases(
 	deepSort bool,
 	returnType string,
 ) bool {
+	return AllTestCasesPassed(JudgeTestCaseResults(outputs, stdout, answerAnyOrder, deepSort, returnType), stdout)
+}
+
+// AllTestCasesPassed reports whether every test case passed and the program
+// printed nothing beyond one line per test case.
+func AllTestCasesPassed(verdicts []TestCaseVerdict, stdout string) bool {
 	parts := utils.SplitStringIntoParts(stdout, "\n")
 
-	if len(outputs) != len(parts) {
+	if len(verdicts) != len(parts) {
 		log.Println("Outputs and parts are different lengths")
 		return false
 	}
 
-	for i := 0; i < len(parts); i++ {
+	for _, verdict := range verdicts {
+		if !verdict.Passed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// JudgeTestCaseResults judges every test case separately. A test case without
+// a matching line of output is reported as failed.
+func JudgeTestCaseResults(
+	outputs []map[string]interface{},
+	stdout string,
+	answerAnyOrder bool,
+	deepSort bool,
+	returnType string,
+) []TestCaseVerdict {
+	parts := utils.SplitStringIntoParts(stdout, "\n")
+	verdicts := make([]TestCaseVerdict, len(outputs))
+
+	for i := range outputs {
+		verdicts[i].Index = i
+
 		outputJsonString, err := utils.ConvertToJSONString(outputs[i]["output"])
 		if err != nil {
 			log.Println("Failed to convert output to JSON string")
-			return false
+			continue
 		}
+		verdicts[i].Expected = outputJsonString
+
+		if i >= len(parts) {
+			log.Printf("Test case %d failed: no output\n", i)
+			continue
+		}
+		verdicts[i].Actual = parts[i]
 
 		result, err := helpers.CompareTestCaseOutputs(outputJsonString, parts[i], answerAnyOrder, deepSort, returnType)
 		if err != nil {
 			log.Printf("Test case %d failed: %v\n", i, err)
-			return false
+			continue
 		}
 
 		if !result {
 			log.Printf("Test case %d failed. Expected %s but got %s\n", i, outputJsonString, parts[i])
-			return false
+			continue
 		}
+
+		verdicts[i].Passed = true
 	}
 
-	return true
+	return verdicts
 }
@@ -2,12 +2,13 @@ package facade
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os/exec"
+	"sync"
 
 	"octree.io-worker/internal/helpers"
 )
@@ -26,17 +27,14 @@ var COMPILERS = map[string]string{
 	"ocaml":      "ocaml5200",
 }
 
-func CompilerExplorer(language string, code string) (string, error) {
+func CompilerExplorer(ctx context.Context, language string, code string) (string, error) {
 	compiler, exists := COMPILERS[language]
 
 	if !exists {
 		return "", fmt.Errorf("unsupported language: %s", language)
 	}
 
-	lang := language
-	if language == "cpp" {
-		lang = "c++"
-	}
+	lang := compilerExplorerLanguage(language)
 
 	payload := map[string]interface{}{
 		"source":   code,
@@ -60,7 +58,7 @@ func CompilerExplorer(language string, code string) (string, error) {
 		return "", fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
 		return "", fmt.Errorf("failed to create HTTP request: %w", err)
 	}
@@ -89,90 +87,149 @@ func CompilerExplorer(language string, code string) (string, error) {
 	return string(body), nil
 }
 
-func ExecuteJavaScript(language string, code string) (string, string, error) {
+func compilerExplorerLanguage(language string) string {
+	if language == "cpp" {
+		return "c++"
+	}
+	return language
+}
+
+var compilerVersions sync.Map
+
+// CompilerVersion returns the name Compiler Explorer shows for the compiler
+// of language, e.g. "x86-64 gcc 14.2". Names are fetched once per compiler.
+func CompilerVersion(ctx context.Context, language string) (string, error) {
+	compiler, exists := COMPILERS[language]
+	if !exists {
+		return "", fmt.Errorf("unsupported language: %s", language)
+	}
+
+	if name, ok := compilerVersions.Load(compiler); ok {
+		return name.(string), nil
+	}
+
+	url := fmt.Sprintf("%s/compilers/%s?fields=id,name", API_URL, compilerExplorerLanguage(language))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", "octree.io")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("error response from API: %s", string(body))
+	}
+
+	var compilers []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&compilers); err != nil {
+		return "", fmt.Errorf("error reading response: %w", err)
+	}
+
+	for _, c := range compilers {
+		if c.Id == compiler {
+			compilerVersions.Store(compiler, c.Name)
+			return c.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("compiler %s not found", compiler)
+}
+
+func ExecuteJavaScript(ctx context.Context, language string, code string) (string, string, int64, error) {
 	tmpFolderDir, err := helpers.CreateTempNpmPackage(language)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create temp npm package: %w", err)
+		return "", "", 0, fmt.Errorf("failed to create temp npm package: %w", err)
 	}
 
 	err = helpers.WriteIndexFile(language, tmpFolderDir, code)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return "", "", fmt.Errorf("failed to write index file: %w", err)
+		return "", "", 0, fmt.Errorf("failed to write index file: %w", err)
 	}
 
-	err = helpers.RunNpmInstall(tmpFolderDir)
+	err = helpers.RunNpmInstall(ctx, tmpFolderDir)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return "", "", fmt.Errorf("failed to run npm install: %w", err)
+		return "", "", 0, fmt.Errorf("failed to run npm install: %w", err)
 	}
 
-	stdout, stderr, err := helpers.BundleNpmPackage(tmpFolderDir)
+	stdout, stderr, err := helpers.BundleNpmPackage(ctx, tmpFolderDir)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return stdout, stderr, fmt.Errorf("failed to bundle npm package: %w", err)
+		return stdout, stderr, 0, fmt.Errorf("failed to bundle npm package: %w", err)
 	}
 
-	stdout, stderr, err = helpers.ExecuteWasmtime(tmpFolderDir)
+	stdout, stderr, memory, err := helpers.ExecuteWasmtime(ctx, tmpFolderDir)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return stdout, stderr, fmt.Errorf("failed to execute Wasmtime: %w", err)
+		return stdout, stderr, memory, fmt.Errorf("failed to execute Wasmtime: %w", err)
 	}
 
 	err = helpers.CleanupTempNpmPackage(tmpFolderDir)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to clean up temp package: %w", err)
+		return "", "", 0, fmt.Errorf("failed to clean up temp package: %w", err)
 	}
 
-	return string(stdout), string(stderr), nil
+	return string(stdout), string(stderr), memory, nil
 }
 
-func ExecuteTypeScript(language string, code string) (string, string, error) {
+func ExecuteTypeScript(ctx context.Context, language string, code string) (string, string, int64, error) {
 	tmpFolderDir, err := helpers.CreateTempNpmPackage(language)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create temp npm package: %w", err)
+		return "", "", 0, fmt.Errorf("failed to create temp npm package: %w", err)
 	}
 
 	err = helpers.WriteIndexFile(language, tmpFolderDir, code)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return "", "", fmt.Errorf("failed to write index file: %w", err)
+		return "", "", 0, fmt.Errorf("failed to write index file: %w", err)
 	}
 
-	err = helpers.RunNpmInstall(tmpFolderDir)
+	err = helpers.RunNpmInstall(ctx, tmpFolderDir)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return "", "", fmt.Errorf("failed to run npm install: %w", err)
+		return "", "", 0, fmt.Errorf("failed to run npm install: %w", err)
 	}
 
-	stdout, stderr, err := compileTypeScript(tmpFolderDir)
+	stdout, stderr, err := compileTypeScript(ctx, tmpFolderDir)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return stdout, stderr, fmt.Errorf("failed to compile TypeScript: %w", err)
+		return stdout, stderr, 0, fmt.Errorf("%w: failed to compile TypeScript: %v", helpers.ErrCompilationFailed, err)
 	}
 
-	stdout, stderr, err = helpers.BundleNpmPackage(tmpFolderDir)
+	stdout, stderr, err = helpers.BundleNpmPackage(ctx, tmpFolderDir)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return stdout, stderr, fmt.Errorf("failed to bundle npm package: %w", err)
+		return stdout, stderr, 0, fmt.Errorf("failed to bundle npm package: %w", err)
 	}
 
-	stdout, stderr, err = helpers.ExecuteWasmtime(tmpFolderDir)
+	stdout, stderr, memory, err := helpers.ExecuteWasmtime(ctx, tmpFolderDir)
 	if err != nil {
 		helpers.CleanupTempNpmPackage(tmpFolderDir)
-		return stdout, stderr, fmt.Errorf("failed to execute Wasmtime: %w", err)
+		return stdout, stderr, memory, fmt.Errorf("failed to execute Wasmtime: %w", err)
 	}
 
 	err = helpers.CleanupTempNpmPackage(tmpFolderDir)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to clean up temp package: %w", err)
+		return "", "", 0, fmt.Errorf("failed to clean up temp package: %w", err)
 	}
 
-	return string(stdout), string(stderr), nil
+	return string(stdout), string(stderr), memory, nil
 }
 
-func compileTypeScript(tmpFolderDir string) (string, string, error) {
-	cmd := exec.Command("npx", "tsc", "index.ts")
+func compileTypeScript(ctx context.Context, tmpFolderDir string) (string, string, error) {
+	cmd := helpers.CommandContext(ctx, "npx", "tsc", "index.ts")
 	cmd.Dir = tmpFolderDir
 
 	stdoutPipe, _ := cmd.StdoutPipe()
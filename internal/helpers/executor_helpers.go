@@ -3,6 +3,7 @@ package helpers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +15,16 @@ import (
 	"github.com/google/uuid"
 )
 
+var (
+	ErrCompilationFailed = errors.New("compilation failed")
+	ErrTimeLimitExceeded = errors.New("time limit exceeded")
+	ErrRuntimeError      = errors.New("runtime error")
+)
+
+// commandWaitDelay is how long a killed command's output is waited for, in
+// case a child that escaped its process group keeps it open.
+const commandWaitDelay = 5 * time.Second
+
 func CreateTempNpmPackage(language string) (string, error) {
 	uuidFolder := uuid.New().String()
 	tmpFolderDir := fmt.Sprintf("/tmp/%s", uuidFolder)
@@ -56,8 +67,8 @@ func WriteIndexFile(language string, tmpFolderDir string, code string) error {
 	return nil
 }
 
-func RunNpmInstall(tmpFolderDir string) error {
-	npmInstallCmd := exec.Command("npm", "install")
+func RunNpmInstall(ctx context.Context, tmpFolderDir string) error {
+	npmInstallCmd := CommandContext(ctx, "npm", "install")
 	npmInstallCmd.Dir = tmpFolderDir
 
 	stdout, stderr, err := RunCommandWithOutput(npmInstallCmd)
@@ -72,8 +83,8 @@ func RunNpmInstall(tmpFolderDir string) error {
 	return nil
 }
 
-func BundleNpmPackage(tmpFolderDir string) (string, string, error) {
-	esbuildCmd := exec.Command("esbuild", "index.js", "--bundle", "--outfile=dist/bundle.js")
+func BundleNpmPackage(ctx context.Context, tmpFolderDir string) (string, string, error) {
+	esbuildCmd := CommandContext(ctx, "esbuild", "index.js", "--bundle", "--outfile=dist/bundle.js")
 	esbuildCmd.Dir = tmpFolderDir
 
 	stdout, stderr, err := RunCommandWithOutput(esbuildCmd)
@@ -82,23 +93,29 @@ func BundleNpmPackage(tmpFolderDir string) (string, string, error) {
 		fmt.Printf("esbuild stdout: %s\n", stdout)
 		fmt.Printf("esbuild stderr: %s\n", stderr)
 		log.Printf("esbuild failed: %s", err)
-		return stdout, stderr, fmt.Errorf("error while bundling with esbuild")
+		return stdout, stderr, fmt.Errorf("%w: error while bundling with esbuild", ErrCompilationFailed)
 	}
 
 	return stdout, stderr, nil
 }
 
-func ExecuteWasmtime(tmpFolderDir string) (string, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// ExecuteWasmtime runs the bundle and returns its stdout, stderr and peak
+// memory in bytes.
+func ExecuteWasmtime(parentCtx context.Context, tmpFolderDir string) (string, string, int64, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, 10*time.Second)
 	defer cancel()
 
 	// Make sure to have js.wasm in /root/untrusted-code-exec/js.wasm and wasmtime installed
-	wasmtimeCmd := exec.CommandContext(ctx, "wasmtime", "run", "--dir=.", "--", "/root/untrusted-code-exec/js.wasm", "dist/bundle.js")
+	wasmtimeCmd := CommandContext(ctx, "wasmtime", "run", "--dir=.", "--", "/root/untrusted-code-exec/js.wasm", "dist/bundle.js")
 	wasmtimeCmd.Dir = tmpFolderDir
 
 	stdout, stderr, err := RunCommandWithOutput(wasmtimeCmd)
+	memory := PeakMemory(wasmtimeCmd)
+	if parentCtx.Err() != nil {
+		return "", "", 0, parentCtx.Err()
+	}
 	if ctx.Err() == context.DeadlineExceeded {
-		return "", "Time limit exceeded", fmt.Errorf("wasm timed out after 10s")
+		return "", "Time limit exceeded", memory, fmt.Errorf("%w: wasm timed out after 10s", ErrTimeLimitExceeded)
 	}
 
 	if err != nil {
@@ -106,10 +123,10 @@ func ExecuteWasmtime(tmpFolderDir string) (string, string, error) {
 		fmt.Printf("wasmtime stdout: %s\n", stdout)
 		fmt.Printf("wasmtime stderr: %s\n", stderr)
 		log.Printf("wasmtime failed: %s", err)
-		return "", "", fmt.Errorf("failed to execute code: %s", err)
+		return stdout, stderr, memory, fmt.Errorf("%w: %s", ErrRuntimeError, err)
 	}
 
-	return string(stdout), string(stderr), nil
+	return string(stdout), string(stderr), memory, nil
 }
 
 func CleanupTempNpmPackage(tmpFolderDir string) error {
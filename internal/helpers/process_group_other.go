@@ -0,0 +1,16 @@
+//go:build !unix
+
+package helpers
+
+import (
+	"context"
+	"os/exec"
+)
+
+// CommandContext is exec.CommandContext, process groups are only used on
+// Unix.
+func CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
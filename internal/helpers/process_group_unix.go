@@ -0,0 +1,22 @@
+//go:build unix
+
+package helpers
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// CommandContext is exec.CommandContext for commands that start children of
+// their own, like npx, npm and wasmtime. The command runs in its own process
+// group, and the whole group is killed when ctx is done.
+func CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package helpers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommandContextKillsChildren(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	// The shell prints the pid of a child that outlives it unless the whole
+	// group is killed.
+	cmd := CommandContext(ctx, "sh", "-c", "sleep 30 & echo $!; wait")
+	started := time.Now()
+	stdout, _, err := RunCommandWithOutput(cmd)
+	if err == nil {
+		t.Fatal("the command wasn't killed")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("the command took %v to stop", elapsed)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(stdout))
+	if err != nil {
+		t.Fatalf("invalid pid %q: %v", stdout, err)
+	}
+
+	// The killed child is reaped by init, which may take a moment.
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("child %d is still running", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
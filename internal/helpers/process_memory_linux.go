@@ -0,0 +1,24 @@
+//go:build linux
+
+package helpers
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// PeakMemory returns the peak resident set size of a finished command in
+// bytes, or 0 when it is unknown.
+func PeakMemory(cmd *exec.Cmd) int64 {
+	if cmd.ProcessState == nil {
+		return 0
+	}
+
+	usage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+
+	// Maxrss is in kilobytes on Linux.
+	return usage.Maxrss * 1024
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package helpers
+
+import "os/exec"
+
+// PeakMemory returns 0, peak memory is only measured on Linux.
+func PeakMemory(cmd *exec.Cmd) int64 {
+	return 0
+}
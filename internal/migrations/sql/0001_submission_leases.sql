@@ -0,0 +1,13 @@
+-- Submissions are claimed by a worker before they are executed, so a
+-- redelivered message for a submission that is already running or done is
+-- skipped instead of being executed twice.
+ALTER TABLE submissions
+  ADD COLUMN IF NOT EXISTS lease_owner TEXT,
+  ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMPTZ;
+
+ALTER TABLE submissions ALTER COLUMN status SET DEFAULT 'PENDING';
+
+CREATE INDEX IF NOT EXISTS submissions_running_lease_idx
+  ON submissions (lease_expires_at)
+  WHERE status = 'RUNNING';
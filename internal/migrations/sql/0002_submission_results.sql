@@ -0,0 +1,39 @@
+-- Results are written as typed JSON (models.SubmissionResult). Outputs written
+-- before were formatted without escaping, so rows that aren't valid JSON are
+-- kept as text under legacyOutput.
+CREATE OR REPLACE FUNCTION octree_submission_result(output TEXT, status TEXT, run_type TEXT) RETURNS JSONB AS $$
+DECLARE
+  result JSONB;
+BEGIN
+  IF output IS NULL OR output = '' THEN
+    RETURN NULL;
+  END IF;
+
+  BEGIN
+    result := output::jsonb;
+  EXCEPTION WHEN others THEN
+    result := jsonb_build_object('legacyOutput', output);
+  END;
+
+  IF jsonb_typeof(result) <> 'object' THEN
+    result := jsonb_build_object('legacyOutput', output);
+  END IF;
+
+  IF NOT result ? 'verdict' THEN
+    result := result || jsonb_build_object('verdict', CASE
+      WHEN status = 'CANCELLED' THEN 'CANCELLED'
+      WHEN result ? 'error' THEN 'ERROR'
+      WHEN run_type = 'submit' AND status = 'SUCCEEDED' THEN 'ACCEPTED'
+      WHEN run_type = 'submit' AND status = 'FAILED' THEN 'WRONG_ANSWER'
+      ELSE 'UNKNOWN'
+    END);
+  END IF;
+
+  RETURN result;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+ALTER TABLE submissions
+  ALTER COLUMN output TYPE JSONB USING octree_submission_result(output::text, status, type);
+
+DROP FUNCTION octree_submission_result(TEXT, TEXT, TEXT);
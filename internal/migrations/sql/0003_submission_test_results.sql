@@ -0,0 +1,16 @@
+-- One row per test case of a finished submission, replaced when the
+-- submission is judged again. The harness runs every case in one process, so
+-- there is no time or memory per case; the submission's totals stay in its
+-- result.
+CREATE TABLE IF NOT EXISTS submission_test_results (
+  submission_id TEXT NOT NULL,
+  problem_id INTEGER NOT NULL,
+  case_index INTEGER NOT NULL,
+  verdict TEXT NOT NULL,
+  output_hash TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (submission_id, case_index)
+);
+
+CREATE INDEX IF NOT EXISTS submission_test_results_problem_idx
+  ON submission_test_results (problem_id, case_index, verdict);
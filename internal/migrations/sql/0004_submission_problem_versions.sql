@@ -0,0 +1,6 @@
+-- The problem revision a submission was judged against. NULL for submissions
+-- judged before problems were versioned, or against an unversioned problem.
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS problem_version INTEGER;
+
+CREATE INDEX IF NOT EXISTS submissions_problem_version_idx
+  ON submissions (problem_id, problem_version);
@@ -0,0 +1,18 @@
+-- Rejudges select submissions by date. Existing rows get the time they were
+-- last claimed, which is close to when they were created, and rows that were
+-- never claimed, or predate claims, the time of the migration.
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_schema = current_schema() AND table_name = 'submissions' AND column_name = 'created_at'
+  ) THEN
+    ALTER TABLE submissions ADD COLUMN created_at TIMESTAMPTZ;
+    UPDATE submissions SET created_at = COALESCE(heartbeat_at, NOW());
+    ALTER TABLE submissions
+      ALTER COLUMN created_at SET DEFAULT NOW(),
+      ALTER COLUMN created_at SET NOT NULL;
+  END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS submissions_created_at_idx ON submissions (created_at);
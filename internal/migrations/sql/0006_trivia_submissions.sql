@@ -0,0 +1,10 @@
+-- Grades of trivia submissions, one element of grades per answer.
+CREATE TABLE IF NOT EXISTS trivia_submissions (
+  submission_id TEXT PRIMARY KEY,
+  room_id TEXT,
+  username TEXT,
+  grades JSONB NOT NULL,
+  graded_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS trivia_submissions_room_idx ON trivia_submissions (room_id);
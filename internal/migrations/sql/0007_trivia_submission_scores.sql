@@ -0,0 +1,19 @@
+-- Room scoring reads totals instead of summing grades. Failed gradings are
+-- stored too, without grades.
+ALTER TABLE trivia_submissions
+  ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'SUCCEEDED',
+  ADD COLUMN IF NOT EXISTS score INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS passed INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS questions INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS error TEXT;
+
+ALTER TABLE trivia_submissions ALTER COLUMN grades SET DEFAULT '[]'::jsonb;
+
+UPDATE trivia_submissions
+SET
+  score = (SELECT COALESCE(SUM((grade->>'score')::int), 0) FROM jsonb_array_elements(grades) AS grade),
+  passed = (SELECT COUNT(*) FROM jsonb_array_elements(grades) AS grade WHERE (grade->>'pass')::boolean),
+  questions = jsonb_array_length(grades)
+WHERE questions = 0;
+
+CREATE INDEX IF NOT EXISTS trivia_submissions_room_user_idx ON trivia_submissions (room_id, username);
@@ -0,0 +1,16 @@
+-- Grades reused for identical answers, keyed by the answer's normalized hash.
+-- Grades are only reused with the model and prompt template text that made
+-- them. Embeddings are stored when similar answers are looked up too.
+CREATE TABLE IF NOT EXISTS trivia_grade_cache (
+  question_id INTEGER NOT NULL,
+  rubric_version INTEGER NOT NULL,
+  prompt_version INTEGER NOT NULL,
+  prompt_hash TEXT NOT NULL,
+  model TEXT NOT NULL,
+  answer_hash TEXT NOT NULL,
+  grade JSONB NOT NULL,
+  embedding REAL[],
+  hits INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (question_id, rubric_version, prompt_version, prompt_hash, model, answer_hash)
+);
@@ -0,0 +1,8 @@
+-- The level of the last hint each user got for a problem.
+CREATE TABLE IF NOT EXISTS hint_levels (
+  username TEXT NOT NULL,
+  problem_id INTEGER NOT NULL,
+  level INTEGER NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (username, problem_id)
+);
@@ -0,0 +1,10 @@
+package models
+
+// CodeReview is the LLM's feedback on a judged submission.
+type CodeReview struct {
+	Summary         string   `json:"summary"`
+	Bugs            []string `json:"bugs"`
+	TimeComplexity  string   `json:"timeComplexity"`
+	SpaceComplexity string   `json:"spaceComplexity"`
+	Style           []string `json:"style"`
+}
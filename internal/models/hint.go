@@ -0,0 +1,20 @@
+package models
+
+// Hint levels, from the least to the most revealing. Each hint request for a
+// problem gets the next level, up to HintPseudocode.
+const (
+	HintNudge      = 1
+	HintApproach   = 2
+	HintPseudocode = 3
+)
+
+var hintTiers = map[int]string{
+	HintNudge:      "NUDGE",
+	HintApproach:   "APPROACH",
+	HintPseudocode: "PSEUDOCODE",
+}
+
+// HintTier returns the name of a hint level.
+func HintTier(level int) string {
+	return hintTiers[level]
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"octree.io-worker/internal/utils"
+)
+
+var ErrInvalidProblem = errors.New("invalid problem")
+
+type TestCase struct {
+	Input  bson.M      `bson:"input" json:"input"`
+	Output interface{} `bson:"output" json:"output"`
+}
+
+// Problem is a problem document from the "problems" collection. Args maps
+// argument names to types from utils.TypeMappings.
+//
+// Version is bumped whenever the test cases change; 0 means the problem is
+// unversioned.
+type Problem struct {
+	ID              int               `bson:"id" json:"id"`
+	Version         int               `bson:"version" json:"version"`
+	Title           string            `bson:"title,omitempty" json:"title,omitempty"`
+	Description     string            `bson:"description,omitempty" json:"description,omitempty"`
+	Args            map[string]string `bson:"args" json:"args"`
+	ReturnType      string            `bson:"returnType" json:"returnType"`
+	AnswerAnyOrder  bool              `bson:"answerAnyOrder" json:"answerAnyOrder"`
+	DeepSort        bool              `bson:"deepSort" json:"deepSort"`
+	SampleTestCases []TestCase        `bson:"sampleTestCases" json:"sampleTestCases"`
+	JudgeTestCases  []TestCase        `bson:"judgeTestCases" json:"judgeTestCases"`
+}
+
+// TestCases returns the test cases a submission of runType is run against.
+func (p *Problem) TestCases(runType string) []TestCase {
+	if runType == "submit" {
+		return p.JudgeTestCases
+	}
+	return p.SampleTestCases
+}
+
+// Validate checks that the types are known, that every test case provides a
+// value of the right type for each argument and that every expected output
+// matches the return type, so a malformed problem fails the submission
+// instead of the harness. Problems may take no arguments.
+func (p *Problem) Validate() error {
+	for name, argType := range p.Args {
+		if !isKnownType(argType) {
+			return fmt.Errorf("%w %d: arg %s has unknown type %q", ErrInvalidProblem, p.ID, name, argType)
+		}
+	}
+
+	if p.ReturnType != "TreeNode-int" && !isKnownType(p.ReturnType) {
+		return fmt.Errorf("%w %d: unknown return type %q", ErrInvalidProblem, p.ID, p.ReturnType)
+	}
+
+	for _, set := range []struct {
+		name      string
+		testCases []TestCase
+	}{
+		{"sampleTestCases", p.SampleTestCases},
+		{"judgeTestCases", p.JudgeTestCases},
+	} {
+		for i, testCase := range set.testCases {
+			if err := p.validateTestCase(testCase); err != nil {
+				return fmt.Errorf("%w %d: %s[%d]: %v", ErrInvalidProblem, p.ID, set.name, i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Problem) validateTestCase(testCase TestCase) error {
+	if testCase.Input == nil && len(p.Args) > 0 {
+		return fmt.Errorf("missing input")
+	}
+
+	for name, argType := range p.Args {
+		value, ok := testCase.Input[name]
+		if !ok {
+			return fmt.Errorf("missing arg %s", name)
+		}
+		if err := checkValue(argType, value); err != nil {
+			return fmt.Errorf("arg %s: %v", name, err)
+		}
+	}
+
+	for name := range testCase.Input {
+		// The harnesses build the tree of a "root" input even when it isn't
+		// an argument, so other TreeNode args can refer to its nodes.
+		if _, ok := p.Args[name]; !ok && name != "root" {
+			return fmt.Errorf("unexpected arg %s", name)
+		}
+	}
+
+	if err := checkValue(p.ReturnType, testCase.Output); err != nil {
+		return fmt.Errorf("output: %v", err)
+	}
+
+	return nil
+}
+
+// isKnownType reports whether any language maps the type. Not every
+// language maps every type, e.g. Python has no long or double.
+func isKnownType(typ string) bool {
+	for _, mapping := range utils.TypeMappings {
+		if _, ok := mapping[typ]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func checkValue(typ string, value interface{}) error {
+	switch {
+	case typ == "void":
+		if value != nil {
+			return fmt.Errorf("expected no value, got %v", value)
+		}
+		return nil
+
+	case typ == "TreeNode":
+		// A tree in level order, or the value of a node in the "root" tree.
+		if isInteger(value) {
+			return nil
+		}
+		return checkList(value, func(elem interface{}) error {
+			if elem == nil || isInteger(elem) {
+				return nil
+			}
+			return fmt.Errorf("expected int or null, got %T", elem)
+		})
+
+	case typ == "TreeNode-int":
+		return checkValue("int", value)
+
+	case typ == "ListNode":
+		return checkList(value, func(elem interface{}) error { return checkValue("int", elem) })
+
+	case typ == "GraphNode":
+		return checkList(value, func(elem interface{}) error { return checkValue("int[]", elem) })
+
+	case strings.HasSuffix(typ, "[]"):
+		elemType := strings.TrimSuffix(typ, "[]")
+		return checkList(value, func(elem interface{}) error { return checkValue(elemType, elem) })
+	}
+
+	switch typ {
+	case "int", "long":
+		if !isInteger(value) {
+			return fmt.Errorf("expected %s, got %T", typ, value)
+		}
+	case "float", "double":
+		if !isInteger(value) {
+			if _, ok := value.(float64); !ok {
+				return fmt.Errorf("expected %s, got %T", typ, value)
+			}
+		}
+	case "bool":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("expected bool, got %T", value)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("expected string, got %T", value)
+		}
+	case "char":
+		if s, ok := value.(string); !ok || len([]rune(s)) != 1 {
+			return fmt.Errorf("expected a single character, got %v", value)
+		}
+	default:
+		return fmt.Errorf("unknown type %q", typ)
+	}
+
+	return nil
+}
+
+func checkList(value interface{}, checkElem func(interface{}) error) error {
+	var elems []interface{}
+	switch v := value.(type) {
+	case nil:
+		// Empty lists, trees and linked lists are stored as null.
+		return nil
+	case primitive.A:
+		elems = v
+	case []interface{}:
+		elems = v
+	default:
+		return fmt.Errorf("expected a list, got %T", value)
+	}
+
+	for i, elem := range elems {
+		if err := checkElem(elem); err != nil {
+			return fmt.Errorf("[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func isInteger(value interface{}) bool {
+	switch v := value.(type) {
+	case int, int32, int64:
+		return true
+	case float64:
+		return v == math.Trunc(v)
+	default:
+		return false
+	}
+}
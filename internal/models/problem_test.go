@@ -0,0 +1,115 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestProblemValidateTypes(t *testing.T) {
+	problem := &Problem{
+		ID:         1,
+		Args:       map[string]string{"n": "long", "xs": "double[]"},
+		ReturnType: "double",
+		JudgeTestCases: []TestCase{
+			{Input: bson.M{"n": 10000000000, "xs": []interface{}{1.5, 2}}, Output: 0.5},
+		},
+	}
+	if err := problem.Validate(); err != nil {
+		t.Errorf("Validate of long and double args = %v, want nil", err)
+	}
+
+	problem.Args["n"] = "bigint"
+	if err := problem.Validate(); !errors.Is(err, ErrInvalidProblem) {
+		t.Errorf("Validate of an unknown type = %v, want ErrInvalidProblem", err)
+	}
+}
+
+func TestProblemValidate(t *testing.T) {
+	sumCase := TestCase{Input: bson.M{"a": 1, "b": 2}, Output: 3}
+
+	for _, test := range []struct {
+		name    string
+		problem Problem
+		// wantErr is part of the error, or empty if the problem is valid.
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			problem: Problem{Args: map[string]string{"a": "int", "b": "int"}, ReturnType: "int", SampleTestCases: []TestCase{sumCase}, JudgeTestCases: []TestCase{sumCase}},
+		},
+		{
+			name:    "no args",
+			problem: Problem{ReturnType: "string", JudgeTestCases: []TestCase{{Input: bson.M{}, Output: "hello"}, {Output: "hello"}}},
+		},
+		{
+			name:    "tree with a root input",
+			problem: Problem{Args: map[string]string{"p": "TreeNode"}, ReturnType: "TreeNode-int", JudgeTestCases: []TestCase{{Input: bson.M{"root": []interface{}{2, 1, nil}, "p": 1}, Output: 2}}},
+		},
+		{
+			name:    "missing input",
+			problem: Problem{Args: map[string]string{"a": "int"}, ReturnType: "int", JudgeTestCases: []TestCase{{Output: 1}}},
+			wantErr: "judgeTestCases[0]: missing input",
+		},
+		{
+			name:    "missing arg",
+			problem: Problem{Args: map[string]string{"a": "int", "b": "int"}, ReturnType: "int", JudgeTestCases: []TestCase{{Input: bson.M{"a": 1}, Output: 1}}},
+			wantErr: "missing arg b",
+		},
+		{
+			name:    "unexpected arg",
+			problem: Problem{Args: map[string]string{"a": "int"}, ReturnType: "int", JudgeTestCases: []TestCase{{Input: bson.M{"a": 1, "c": 2}, Output: 1}}},
+			wantErr: "unexpected arg c",
+		},
+		{
+			name:    "wrong arg type",
+			problem: Problem{Args: map[string]string{"s": "string"}, ReturnType: "int", JudgeTestCases: []TestCase{{Input: bson.M{"s": 1}, Output: 1}}},
+			wantErr: "arg s: expected string",
+		},
+		{
+			name:    "wrong output type",
+			problem: Problem{Args: map[string]string{"a": "int"}, ReturnType: "bool", JudgeTestCases: []TestCase{{Input: bson.M{"a": 1}, Output: 1}}},
+			wantErr: "output: expected bool",
+		},
+		{
+			name:    "array that isn't a list",
+			problem: Problem{Args: map[string]string{"xs": "int[]"}, ReturnType: "int", JudgeTestCases: []TestCase{{Input: bson.M{"xs": "1,2"}, Output: 1}}},
+			wantErr: "arg xs: expected a list",
+		},
+		{
+			name:    "wrong array element",
+			problem: Problem{Args: map[string]string{"xs": "int[]"}, ReturnType: "int", JudgeTestCases: []TestCase{{Input: bson.M{"xs": []interface{}{1, 2.5}}, Output: 1}}},
+			wantErr: "arg xs: [1]: expected int",
+		},
+		{
+			name: "judge cases that don't match the sample cases",
+			problem: Problem{
+				Args:            map[string]string{"a": "int", "b": "int"},
+				ReturnType:      "int",
+				SampleTestCases: []TestCase{sumCase},
+				JudgeTestCases:  []TestCase{sumCase, {Input: bson.M{"x": 1, "y": 2}, Output: 3}},
+			},
+			wantErr: "judgeTestCases[1]: missing arg",
+		},
+		{
+			name:    "unknown return type",
+			problem: Problem{Args: map[string]string{"a": "int"}, ReturnType: "number"},
+			wantErr: `unknown return type "number"`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.problem.Validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidProblem) || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Validate = %v, want ErrInvalidProblem with %q", err, test.wantErr)
+			}
+		})
+	}
+}
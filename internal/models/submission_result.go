@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	VerdictAccepted          = "ACCEPTED"
+	VerdictWrongAnswer       = "WRONG_ANSWER"
+	VerdictCompilationError  = "COMPILATION_ERROR"
+	VerdictRuntimeError      = "RUNTIME_ERROR"
+	VerdictTimeLimitExceeded = "TIME_LIMIT_EXCEEDED"
+	VerdictCancelled         = "CANCELLED"
+
+	// VerdictError means the submission couldn't be judged, see Error.
+	VerdictError = "ERROR"
+)
+
+type TestResult struct {
+	Index    int    `json:"index"`
+	Passed   bool   `json:"passed"`
+	Verdict  string `json:"verdict"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+
+	// OutputHash is the SHA-256 of the untruncated actual output.
+	OutputHash string `json:"outputHash,omitempty"`
+}
+
+// SubmissionResult is stored as JSON in submissions.output. stdout, stderr and
+// execTime keep the keys of the untyped output it replaces.
+type SubmissionResult struct {
+	Verdict         string       `json:"verdict"`
+	TestResults     []TestResult `json:"testResults,omitempty"`
+	Stdout          string       `json:"stdout"`
+	StdoutTruncated bool         `json:"stdoutTruncated,omitempty"`
+	Stderr          string       `json:"stderr"`
+	StderrTruncated bool         `json:"stderrTruncated,omitempty"`
+
+	// ExecTime is the run time reported by the runner and WallTime the time
+	// the worker waited for it, both in milliseconds.
+	ExecTime int `json:"execTime"`
+	WallTime int `json:"wallTime"`
+
+	// Memory is the peak memory in bytes, or 0 when the runner doesn't
+	// report it.
+	Memory          int64  `json:"memory,omitempty"`
+	Compiler        string `json:"compiler,omitempty"`
+	CompilerVersion string `json:"compilerVersion,omitempty"`
+
+	// ProblemVersion is the problem revision the submission was judged
+	// against.
+	ProblemVersion int `json:"problemVersion,omitempty"`
+
+	Error string `json:"error,omitempty"`
+
+	// LegacyOutput holds outputs written before results were typed that
+	// weren't valid JSON.
+	LegacyOutput string `json:"legacyOutput,omitempty"`
+}
+
+// TruncateOutput limits stdout, stderr and the values of each test result to
+// limit bytes, marking where they were cut. A limit of 0 keeps everything.
+func (r *SubmissionResult) TruncateOutput(limit int) {
+	r.Stdout, r.StdoutTruncated = truncate(r.Stdout, limit)
+	r.Stderr, r.StderrTruncated = truncate(r.Stderr, limit)
+
+	for i := range r.TestResults {
+		r.TestResults[i].Expected, _ = truncate(r.TestResults[i].Expected, limit)
+		r.TestResults[i].Actual, _ = truncate(r.TestResults[i].Actual, limit)
+	}
+}
+
+func truncate(s string, limit int) (string, bool) {
+	if limit <= 0 || len(s) <= limit {
+		return s, false
+	}
+
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+
+	return s[:cut] + fmt.Sprintf("\n... [truncated %d bytes]", len(s)-cut), true
+}
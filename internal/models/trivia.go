@@ -0,0 +1,55 @@
+package models
+
+// TriviaQuestion is a document from the "trivia_questions" collection.
+type TriviaQuestion struct {
+	ID       int    `bson:"id" json:"id"`
+	Question string `bson:"question" json:"question"`
+
+	// Answer is the reference answer graders compare answers against.
+	Answer string `bson:"answer" json:"answer"`
+
+	Rubric *TriviaRubric `bson:"rubric,omitempty" json:"rubric,omitempty"`
+
+	// PromptVersion pins the grading prompt template, 0 being the latest.
+	PromptVersion int `bson:"promptVersion,omitempty" json:"promptVersion,omitempty"`
+}
+
+const (
+	StrictnessLenient  = "lenient"
+	StrictnessStandard = "standard"
+	StrictnessStrict   = "strict"
+)
+
+// TriviaRubric is what graders look for in an answer. Version is bumped when
+// the rubric changes, so grades can tell which one they used.
+type TriviaRubric struct {
+	Version        int      `bson:"version" json:"version"`
+	KeyPoints      []string `bson:"keyPoints" json:"keyPoints"`
+	MustMention    []string `bson:"mustMention" json:"mustMention"`
+	Misconceptions []string `bson:"misconceptions" json:"misconceptions"`
+	Strictness     string   `bson:"strictness" json:"strictness"`
+}
+
+// PromptTemplate is a text/template from the "prompt_templates" collection,
+// versioned per Name.
+type PromptTemplate struct {
+	Name     string `bson:"name" json:"name"`
+	Version  int    `bson:"version" json:"version"`
+	Template string `bson:"template" json:"template"`
+}
+
+// TriviaGrade is the grade of one answer of a trivia submission. Score is
+// out of 100.
+type TriviaGrade struct {
+	QuestionId    int    `json:"questionId"`
+	Answer        string `json:"answer"`
+	Pass          bool   `json:"pass"`
+	Score         int    `json:"score"`
+	Explanation   string `json:"explanation"`
+	CorrectAnswer string `json:"correctAnswer"`
+	RubricVersion int    `json:"rubricVersion,omitempty"`
+	PromptVersion int    `json:"promptVersion,omitempty"`
+
+	// Cached is set on grades reused from an identical or similar answer.
+	Cached bool `json:"cached,omitempty"`
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/utils"
+)
+
+// FileProblemRepository reads problems from <dir>/<id>.json and keeps
+// revisions in <dir>/revisions, for local development. Files are read on every
+// lookup, so edits apply once the problem cache polls them.
+type FileProblemRepository struct {
+	dir string
+}
+
+func NewFileProblemRepository(dir string) *FileProblemRepository {
+	return &FileProblemRepository{dir: dir}
+}
+
+func (r *FileProblemRepository) GetByID(ctx context.Context, id int) (*models.Problem, error) {
+	return readProblem(filepath.Join(r.dir, strconv.Itoa(id)+".json"), id)
+}
+
+func (r *FileProblemRepository) revisionPath(id int, version int) string {
+	return filepath.Join(r.dir, "revisions", fmt.Sprintf("%d-v%d.json", id, version))
+}
+
+func (r *FileProblemRepository) GetRevision(ctx context.Context, id int, version int) (*models.Problem, error) {
+	problem, err := r.GetByID(ctx, id)
+	if version == 0 || (err == nil && problem.Version == version) {
+		return problem, err
+	}
+
+	return readProblem(r.revisionPath(id, version), id)
+}
+
+func (r *FileProblemRepository) SaveRevision(ctx context.Context, problem *models.Problem) error {
+	path := r.revisionPath(problem.ID, problem.Version)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(problem, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func readProblem(path string, id int) (*models.Problem, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// Keep integers as integers, like problems decoded from BSON.
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var problem models.Problem
+	if err := decoder.Decode(&problem); err != nil {
+		return nil, fmt.Errorf("%w %d: %v", models.ErrInvalidProblem, id, err)
+	}
+
+	for _, testCases := range [][]models.TestCase{problem.SampleTestCases, problem.JudgeTestCases} {
+		for i := range testCases {
+			input, _ := utils.ConvertJSONNumbers(map[string]interface{}(testCases[i].Input)).(map[string]interface{})
+			testCases[i].Input = bson.M(input)
+			testCases[i].Output = utils.ConvertJSONNumbers(testCases[i].Output)
+		}
+	}
+
+	if err := problem.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &problem, nil
+}
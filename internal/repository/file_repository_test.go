@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"octree.io-worker/internal/models"
+)
+
+func TestFileSubmissionRepositoryReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data", "submissions.json")
+
+	submissions, err := NewFileSubmissionRepository(path)
+	if err != nil {
+		t.Fatalf("NewFileSubmissionRepository of a missing file: %v", err)
+	}
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, submission := range []Submission{
+		{SubmissionId: "judged", ProblemId: 1, Language: "python", Code: "print(1)", RunType: "submit", CreatedAt: created},
+		{SubmissionId: "running", ProblemId: 2, RunType: "run", CreatedAt: created.Add(time.Minute)},
+	} {
+		if err := submissions.Put(submission); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	if _, err := submissions.Claim(ctx, "judged", "worker-1", time.Minute); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	result := &models.SubmissionResult{Verdict: models.VerdictAccepted, ProblemVersion: 2}
+	if owned, err := submissions.Complete(ctx, "judged", "worker-1", result, "SUCCEEDED"); err != nil || !owned {
+		t.Fatalf("Complete = %v, %v, want true", owned, err)
+	}
+	if _, err := submissions.Claim(ctx, "running", "worker-1", time.Hour); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	reloaded, err := NewFileSubmissionRepository(path)
+	if err != nil {
+		t.Fatalf("NewFileSubmissionRepository: %v", err)
+	}
+
+	judged, err := reloaded.Get(ctx, "judged")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if judged.Status != "SUCCEEDED" || judged.Code != "print(1)" || !judged.CreatedAt.Equal(created) ||
+		judged.Result == nil || judged.Result.Verdict != models.VerdictAccepted || judged.ProblemVersion != 2 {
+		t.Errorf("reloaded submission = %+v, want the judged submission", judged)
+	}
+
+	// Leases aren't saved, so the submission left running can be claimed by
+	// the next run.
+	if _, err := reloaded.Claim(ctx, "running", "worker-2", time.Minute); err != nil {
+		t.Errorf("Claim of a submission left RUNNING = %v, want nil", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the temporary file is left behind: %v", err)
+	}
+}
+
+func TestFileSubmissionRepositoryMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.json")
+	if err := os.WriteFile(path, []byte("[{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileSubmissionRepository(path); err == nil {
+		t.Error("NewFileSubmissionRepository of a malformed file = nil, want an error")
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"octree.io-worker/internal/models"
+)
+
+// FileSubmissionRepository is a MemorySubmissionRepository that saves every
+// change to a JSON file, for local development. Leases aren't saved, so
+// submissions left RUNNING by a previous run can be claimed right away.
+type FileSubmissionRepository struct {
+	*MemorySubmissionRepository
+	path string
+	save sync.Mutex
+}
+
+func NewFileSubmissionRepository(path string) (*FileSubmissionRepository, error) {
+	r := &FileSubmissionRepository{
+		MemorySubmissionRepository: NewMemorySubmissionRepository(),
+		path:                       path,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var submissions []Submission
+	if err := json.Unmarshal(data, &submissions); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, submission := range submissions {
+		r.MemorySubmissionRepository.Put(submission)
+	}
+
+	return r, nil
+}
+
+// persist writes all submissions to the file, replacing it atomically.
+func (r *FileSubmissionRepository) persist() error {
+	r.save.Lock()
+	defer r.save.Unlock()
+
+	r.mu.Lock()
+	submissions := make([]Submission, 0, len(r.submissions))
+	for _, stored := range r.submissions {
+		submissions = append(submissions, stored.submission)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(submissions, func(i, j int) bool {
+		return submissions[i].CreatedAt.Before(submissions[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(submissions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := r.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, r.path)
+}
+
+// saved persists after a successful change and logs failures, so a broken
+// file doesn't fail the submission.
+func (r *FileSubmissionRepository) saved(changed bool, err error) (bool, error) {
+	if err == nil && changed {
+		if err := r.persist(); err != nil {
+			log.Printf("Failed to save submissions to %s: %v", r.path, err)
+		}
+	}
+	return changed, err
+}
+
+func (r *FileSubmissionRepository) Put(submission Submission) error {
+	r.MemorySubmissionRepository.Put(submission)
+	return r.persist()
+}
+
+func (r *FileSubmissionRepository) Claim(ctx context.Context, submissionId string, owner string, lease time.Duration) (*Submission, error) {
+	submission, err := r.MemorySubmissionRepository.Claim(ctx, submissionId, owner, lease)
+	r.saved(err == nil, err)
+	return submission, err
+}
+
+func (r *FileSubmissionRepository) Complete(ctx context.Context, submissionId string, owner string, result *models.SubmissionResult, status string) (bool, error) {
+	return r.saved(r.MemorySubmissionRepository.Complete(ctx, submissionId, owner, result, status))
+}
+
+func (r *FileSubmissionRepository) SaveRejudge(ctx context.Context, submissionId string, result *models.SubmissionResult, status string) (bool, error) {
+	return r.saved(r.MemorySubmissionRepository.SaveRejudge(ctx, submissionId, result, status))
+}
+
+func (r *FileSubmissionRepository) Requeue(ctx context.Context, submissionId string, version int) (bool, error) {
+	return r.saved(r.MemorySubmissionRepository.Requeue(ctx, submissionId, version))
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"sync"
+)
+
+type HintLevelRepository interface {
+	// NextHintLevel advances the level of the last hint user got for a
+	// problem by one, up to maxLevel, and returns it. Concurrent calls get
+	// different levels until maxLevel is reached.
+	NextHintLevel(ctx context.Context, user string, problemId int, maxLevel int) (int, error)
+
+	// UndoHintLevel takes back a level NextHintLevel returned when no hint was
+	// given for it, unless a later call already advanced past it.
+	UndoHintLevel(ctx context.Context, user string, problemId int, level int) error
+}
+
+type hintLevelKey struct {
+	user      string
+	problemId int
+}
+
+// MemoryHintLevelRepository keeps hint levels in memory, for tests and local
+// development.
+type MemoryHintLevelRepository struct {
+	mu     sync.Mutex
+	levels map[hintLevelKey]int
+}
+
+func NewMemoryHintLevelRepository() *MemoryHintLevelRepository {
+	return &MemoryHintLevelRepository{levels: make(map[hintLevelKey]int)}
+}
+
+func (r *MemoryHintLevelRepository) NextHintLevel(ctx context.Context, user string, problemId int, maxLevel int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := hintLevelKey{user, problemId}
+	r.levels[key] = min(r.levels[key]+1, maxLevel)
+	return r.levels[key], nil
+}
+
+func (r *MemoryHintLevelRepository) UndoHintLevel(ctx context.Context, user string, problemId int, level int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := hintLevelKey{user, problemId}
+	if r.levels[key] == level {
+		r.levels[key] = level - 1
+	}
+	return nil
+}
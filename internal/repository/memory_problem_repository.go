@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"octree.io-worker/internal/models"
+)
+
+// MemoryProblemRepository keeps problems in memory, for tests and local
+// development.
+type MemoryProblemRepository struct {
+	mu        sync.RWMutex
+	problems  map[int]*models.Problem
+	revisions map[[2]int]*models.Problem
+}
+
+func NewMemoryProblemRepository() *MemoryProblemRepository {
+	return &MemoryProblemRepository{
+		problems:  make(map[int]*models.Problem),
+		revisions: make(map[[2]int]*models.Problem),
+	}
+}
+
+func (r *MemoryProblemRepository) Put(problem *models.Problem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.problems[problem.ID] = problem
+}
+
+func (r *MemoryProblemRepository) GetByID(ctx context.Context, id int) (*models.Problem, error) {
+	r.mu.RLock()
+	problem, ok := r.problems[id]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if err := problem.Validate(); err != nil {
+		return nil, err
+	}
+	return problem, nil
+}
+
+func (r *MemoryProblemRepository) GetRevision(ctx context.Context, id int, version int) (*models.Problem, error) {
+	if version == 0 {
+		return r.GetByID(ctx, id)
+	}
+
+	r.mu.RLock()
+	problem, ok := r.problems[id]
+	if !ok || problem.Version != version {
+		problem, ok = r.revisions[[2]int{id, version}]
+	}
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if err := problem.Validate(); err != nil {
+		return nil, err
+	}
+	return problem, nil
+}
+
+func (r *MemoryProblemRepository) SaveRevision(ctx context.Context, problem *models.Problem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := [2]int{problem.ID, problem.Version}
+	if _, ok := r.revisions[key]; !ok {
+		r.revisions[key] = problem
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"octree.io-worker/internal/models"
+)
+
+func TestMemorySubmissionRepositoryLease(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	submissions := NewMemorySubmissionRepository()
+	submissions.now = func() time.Time { return now }
+	submissions.Put(Submission{SubmissionId: "s1", ProblemId: 1})
+
+	if _, err := submissions.Claim(ctx, "s1", "worker-1", time.Minute); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if _, err := submissions.Claim(ctx, "s1", "worker-2", time.Minute); !errors.Is(err, ErrSubmissionLeaseHeld) {
+		t.Fatalf("second Claim = %v, want ErrSubmissionLeaseHeld", err)
+	}
+
+	// The lease expires when its owner stops extending it, and the
+	// submission can be taken over.
+	now = now.Add(2 * time.Minute)
+	if _, err := submissions.Claim(ctx, "s1", "worker-2", time.Minute); err != nil {
+		t.Fatalf("Claim after expiry: %v", err)
+	}
+
+	if owned, err := submissions.ExtendLease(ctx, "s1", "worker-1", time.Minute); err != nil || owned {
+		t.Fatalf("ExtendLease by the old owner = %v, %v, want false", owned, err)
+	}
+	if owned, err := submissions.Complete(ctx, "s1", "worker-1", &models.SubmissionResult{Verdict: models.VerdictAccepted}, "SUCCEEDED"); err != nil || owned {
+		t.Fatalf("Complete by the old owner = %v, %v, want false", owned, err)
+	}
+
+	result := &models.SubmissionResult{Verdict: models.VerdictWrongAnswer, ProblemVersion: 3}
+	if owned, err := submissions.Complete(ctx, "s1", "worker-2", result, "FAILED"); err != nil || !owned {
+		t.Fatalf("Complete = %v, %v, want true", owned, err)
+	}
+
+	stored, err := submissions.Get(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Status != "FAILED" || stored.Result.Verdict != models.VerdictWrongAnswer || stored.ProblemVersion != 3 {
+		t.Errorf("stored submission = %+v, want the FAILED result of version 3", stored)
+	}
+
+	if _, err := submissions.Claim(ctx, "s1", "worker-3", time.Minute); !errors.Is(err, ErrSubmissionAlreadyProcessed) {
+		t.Errorf("Claim of a finished submission = %v, want ErrSubmissionAlreadyProcessed", err)
+	}
+	if _, err := submissions.Claim(ctx, "missing", "worker-1", time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Claim of a missing submission = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryProblemRepositoryRevisions(t *testing.T) {
+	ctx := context.Background()
+	problem := func(version int, output int) *models.Problem {
+		return &models.Problem{
+			ID:             1,
+			Version:        version,
+			Args:           map[string]string{"n": "int"},
+			ReturnType:     "int",
+			JudgeTestCases: []models.TestCase{{Input: bson.M{"n": 1}, Output: output}},
+		}
+	}
+
+	problems := NewMemoryProblemRepository()
+	problems.Put(problem(1, 1))
+	if err := problems.SaveRevision(ctx, problem(1, 1)); err != nil {
+		t.Fatalf("SaveRevision: %v", err)
+	}
+	problems.Put(problem(2, 2))
+
+	current, err := problems.GetRevision(ctx, 1, 0)
+	if err != nil || current.Version != 2 {
+		t.Fatalf("GetRevision(0) = %+v, %v, want version 2", current, err)
+	}
+
+	old, err := problems.GetRevision(ctx, 1, 1)
+	if err != nil || old.JudgeTestCases[0].Output != 1 {
+		t.Fatalf("GetRevision(1) = %+v, %v, want the saved version 1", old, err)
+	}
+
+	if _, err := problems.GetRevision(ctx, 1, 5); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRevision(5) = %v, want ErrNotFound", err)
+	}
+
+	problems.Put(&models.Problem{ID: 2, ReturnType: "number"})
+	if _, err := problems.GetByID(ctx, 2); !errors.Is(err, models.ErrInvalidProblem) {
+		t.Errorf("GetByID of a problem with an unknown return type = %v, want ErrInvalidProblem", err)
+	}
+}
+
+func TestMemoryHintLevelRepository(t *testing.T) {
+	ctx := context.Background()
+	levels := NewMemoryHintLevelRepository()
+
+	for _, want := range []int{1, 2, 2} {
+		if level, err := levels.NextHintLevel(ctx, "alice", 1, 2); err != nil || level != want {
+			t.Errorf("NextHintLevel = %d, %v, want %d", level, err, want)
+		}
+	}
+
+	// A level is only given back while it is the last one.
+	if err := levels.UndoHintLevel(ctx, "alice", 1, 1); err != nil {
+		t.Fatalf("UndoHintLevel: %v", err)
+	}
+	if level, _ := levels.NextHintLevel(ctx, "alice", 1, 3); level != 3 {
+		t.Errorf("NextHintLevel after undoing an old level = %d, want 3", level)
+	}
+	if err := levels.UndoHintLevel(ctx, "alice", 1, 3); err != nil {
+		t.Fatalf("UndoHintLevel: %v", err)
+	}
+	if level, _ := levels.NextHintLevel(ctx, "alice", 1, 3); level != 3 {
+		t.Errorf("NextHintLevel after undoing the last level = %d, want 3", level)
+	}
+
+	if level, _ := levels.NextHintLevel(ctx, "bob", 1, 3); level != 1 {
+		t.Errorf("NextHintLevel of another user = %d, want 1", level)
+	}
+}
+
+func TestMemorySubmissionRepositoryListFinished(t *testing.T) {
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	submissions := NewMemorySubmissionRepository()
+	for _, submission := range []Submission{
+		{SubmissionId: "p1-room-a", ProblemId: 1, RoomId: "a", Language: "python", RunType: "submit", Status: "SUCCEEDED", CreatedAt: day(2)},
+		{SubmissionId: "p1-go", ProblemId: 1, Language: "go", RunType: "submit", Status: "FAILED", CreatedAt: day(1)},
+		{SubmissionId: "p1-room-b", ProblemId: 1, RoomId: "b", Language: "python", RunType: "submit", Status: "FAILED", CreatedAt: day(3)},
+		{SubmissionId: "p2-room-a", ProblemId: 2, RoomId: "a", Language: "python", RunType: "submit", Status: "SUCCEEDED", CreatedAt: day(2)},
+		{SubmissionId: "p1-run", ProblemId: 1, Language: "python", RunType: "run", Status: "SUCCEEDED", CreatedAt: day(2)},
+		{SubmissionId: "p1-running", ProblemId: 1, Language: "python", RunType: "submit", Status: "RUNNING", CreatedAt: day(2)},
+	} {
+		submissions.Put(submission)
+	}
+
+	for _, test := range []struct {
+		name   string
+		filter SubmissionFilter
+		want   []string
+	}{
+		{"everything", SubmissionFilter{}, []string{"p1-go", "p1-room-a", "p1-run", "p2-room-a", "p1-room-b"}},
+		{"problem", SubmissionFilter{ProblemId: 1, RunType: "submit"}, []string{"p1-go", "p1-room-a", "p1-room-b"}},
+		{"room", SubmissionFilter{RoomId: "a"}, []string{"p1-room-a", "p2-room-a"}},
+		{"problem and room", SubmissionFilter{ProblemId: 1, RoomId: "a"}, []string{"p1-room-a"}},
+		{"language", SubmissionFilter{Language: "go"}, []string{"p1-go"}},
+		{"since", SubmissionFilter{RunType: "submit", Since: day(2)}, []string{"p1-room-a", "p2-room-a", "p1-room-b"}},
+		{"until", SubmissionFilter{RunType: "submit", Until: day(2)}, []string{"p1-go"}},
+		{"since and until", SubmissionFilter{Since: day(2), Until: day(3)}, []string{"p1-room-a", "p1-run", "p2-room-a"}},
+		{"room and dates", SubmissionFilter{RoomId: "b", Since: day(1), Until: day(3)}, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			listed, err := submissions.ListFinished(ctx, test.filter)
+			if err != nil {
+				t.Fatalf("ListFinished: %v", err)
+			}
+			var ids []string
+			for _, submission := range listed {
+				ids = append(ids, submission.SubmissionId)
+			}
+			if !slices.Equal(ids, test.want) {
+				t.Errorf("ListFinished = %v, want %v", ids, test.want)
+			}
+		})
+	}
+
+	// Requeued submissions are pending, pinned to the version, and no longer
+	// finished.
+	if requeued, err := submissions.Requeue(ctx, "p1-go", 3); err != nil || !requeued {
+		t.Fatalf("Requeue = %v, %v, want true", requeued, err)
+	}
+	stored, _ := submissions.Get(ctx, "p1-go")
+	if stored.Status != "PENDING" || stored.ProblemVersion != 3 {
+		t.Errorf("requeued submission = %+v, want PENDING on version 3", stored)
+	}
+	if requeued, err := submissions.Requeue(ctx, "p1-go", 3); err != nil || requeued {
+		t.Errorf("Requeue of a pending submission = %v, %v, want false", requeued, err)
+	}
+	if requeued, err := submissions.Requeue(ctx, "p1-running", 0); err != nil || requeued {
+		t.Errorf("Requeue of a running submission = %v, %v, want false", requeued, err)
+	}
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"octree.io-worker/internal/models"
+)
+
+type memorySubmission struct {
+	submission     Submission
+	leaseOwner     string
+	leaseExpiresAt *time.Time
+}
+
+// MemorySubmissionRepository keeps submissions in memory, for tests and
+// local development.
+type MemorySubmissionRepository struct {
+	mu          sync.Mutex
+	submissions map[string]*memorySubmission
+	now         func() time.Time
+}
+
+func NewMemorySubmissionRepository() *MemorySubmissionRepository {
+	return &MemorySubmissionRepository{
+		submissions: make(map[string]*memorySubmission),
+		now:         time.Now,
+	}
+}
+
+// Put adds or replaces a submission. An empty status is stored as PENDING and
+// an empty CreatedAt as now.
+func (r *MemorySubmissionRepository) Put(submission Submission) {
+	if submission.Status == "" {
+		submission.Status = "PENDING"
+	}
+	if submission.CreatedAt.IsZero() {
+		submission.CreatedAt = r.now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.submissions[submission.SubmissionId] = &memorySubmission{submission: submission}
+}
+
+func (r *MemorySubmissionRepository) Get(ctx context.Context, submissionId string) (*Submission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.submissions[submissionId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	submission := stored.submission
+	return &submission, nil
+}
+
+func (r *MemorySubmissionRepository) Claim(ctx context.Context, submissionId string, owner string, lease time.Duration) (*Submission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.submissions[submissionId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	now := r.now()
+	switch stored.submission.Status {
+	case "PENDING":
+	case "RUNNING":
+		if stored.leaseExpiresAt != nil && !stored.leaseExpiresAt.Before(now) {
+			return nil, ErrSubmissionLeaseHeld
+		}
+	default:
+		return nil, ErrSubmissionAlreadyProcessed
+	}
+
+	expiresAt := now.Add(lease)
+	stored.submission.Status = "RUNNING"
+	stored.leaseOwner = owner
+	stored.leaseExpiresAt = &expiresAt
+
+	submission := stored.submission
+	return &submission, nil
+}
+
+func (r *MemorySubmissionRepository) LeaseExpiry(ctx context.Context, submissionId string) (*time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.submissions[submissionId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return stored.leaseExpiresAt, nil
+}
+
+func (r *MemorySubmissionRepository) ExtendLease(ctx context.Context, submissionId string, owner string, lease time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.submissions[submissionId]
+	if !ok || stored.leaseOwner != owner || stored.submission.Status != "RUNNING" {
+		return false, nil
+	}
+
+	expiresAt := r.now().Add(lease)
+	stored.leaseExpiresAt = &expiresAt
+	return true, nil
+}
+
+func (r *MemorySubmissionRepository) Complete(ctx context.Context, submissionId string, owner string, result *models.SubmissionResult, status string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.submissions[submissionId]
+	if !ok || stored.leaseOwner != owner || stored.submission.Status != "RUNNING" {
+		return false, nil
+	}
+
+	stored.submission.Result = result
+	if result.ProblemVersion != 0 {
+		stored.submission.ProblemVersion = result.ProblemVersion
+	}
+	stored.submission.Status = status
+	stored.leaseExpiresAt = nil
+	return true, nil
+}
+
+func (r *MemorySubmissionRepository) ListFinished(ctx context.Context, filter SubmissionFilter) ([]*Submission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var submissions []*Submission
+	for _, stored := range r.submissions {
+		submission := stored.submission
+		if submission.Status != "SUCCEEDED" && submission.Status != "FAILED" {
+			continue
+		}
+		if filter.ProblemId != 0 && submission.ProblemId != filter.ProblemId {
+			continue
+		}
+		if filter.RoomId != "" && submission.RoomId != filter.RoomId {
+			continue
+		}
+		if filter.Language != "" && submission.Language != filter.Language {
+			continue
+		}
+		if filter.RunType != "" && submission.RunType != filter.RunType {
+			continue
+		}
+		if !filter.Since.IsZero() && submission.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !submission.CreatedAt.Before(filter.Until) {
+			continue
+		}
+		submissions = append(submissions, &submission)
+	}
+
+	sort.Slice(submissions, func(i, j int) bool {
+		if !submissions[i].CreatedAt.Equal(submissions[j].CreatedAt) {
+			return submissions[i].CreatedAt.Before(submissions[j].CreatedAt)
+		}
+		return submissions[i].SubmissionId < submissions[j].SubmissionId
+	})
+	return submissions, nil
+}
+
+func (r *MemorySubmissionRepository) SaveRejudge(ctx context.Context, submissionId string, result *models.SubmissionResult, status string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.submissions[submissionId]
+	if !ok || (stored.submission.Status != "SUCCEEDED" && stored.submission.Status != "FAILED") {
+		return false, nil
+	}
+
+	stored.submission.Result = result
+	if result.ProblemVersion != 0 {
+		stored.submission.ProblemVersion = result.ProblemVersion
+	}
+	stored.submission.Status = status
+	return true, nil
+}
+
+func (r *MemorySubmissionRepository) Requeue(ctx context.Context, submissionId string, version int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.submissions[submissionId]
+	if !ok || (stored.submission.Status != "SUCCEEDED" && stored.submission.Status != "FAILED") {
+		return false, nil
+	}
+
+	stored.submission.Status = "PENDING"
+	stored.submission.ProblemVersion = version
+	stored.leaseOwner = ""
+	stored.leaseExpiresAt = nil
+	return true, nil
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"octree.io-worker/internal/models"
+)
+
+// MemoryTriviaQuestionRepository keeps trivia questions in memory, for tests
+// and local development.
+type MemoryTriviaQuestionRepository struct {
+	mu        sync.RWMutex
+	questions map[int]*models.TriviaQuestion
+}
+
+func NewMemoryTriviaQuestionRepository() *MemoryTriviaQuestionRepository {
+	return &MemoryTriviaQuestionRepository{questions: make(map[int]*models.TriviaQuestion)}
+}
+
+// LoadTriviaQuestions reads a JSON list of questions. A missing file has no
+// questions.
+func LoadTriviaQuestions(path string) (*MemoryTriviaQuestionRepository, error) {
+	r := NewMemoryTriviaQuestionRepository()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var questions []*models.TriviaQuestion
+	if err := json.Unmarshal(data, &questions); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, question := range questions {
+		r.Put(question)
+	}
+
+	return r, nil
+}
+
+func (r *MemoryTriviaQuestionRepository) Put(question *models.TriviaQuestion) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.questions[question.ID] = question
+}
+
+func (r *MemoryTriviaQuestionRepository) GetByIDs(ctx context.Context, ids []int) (map[int]*models.TriviaQuestion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []*models.TriviaQuestion
+	for _, id := range ids {
+		if question, ok := r.questions[id]; ok {
+			found = append(found, question)
+		}
+	}
+
+	return triviaQuestionsByID(found, ids)
+}
+
+// MemoryTriviaSubmissionRepository keeps graded trivia submissions in memory,
+// for tests and local development.
+type MemoryTriviaSubmissionRepository struct {
+	mu          sync.Mutex
+	submissions map[string]*TriviaSubmission
+}
+
+func NewMemoryTriviaSubmissionRepository() *MemoryTriviaSubmissionRepository {
+	return &MemoryTriviaSubmissionRepository{submissions: make(map[string]*TriviaSubmission)}
+}
+
+func (r *MemoryTriviaSubmissionRepository) Save(ctx context.Context, submission *TriviaSubmission) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := *submission
+	r.submissions[submission.SubmissionId] = &saved
+	return nil
+}
+
+func (r *MemoryTriviaSubmissionRepository) Get(ctx context.Context, submissionId string) (*TriviaSubmission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	submission, ok := r.submissions[submissionId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	saved := *submission
+	return &saved, nil
+}
+
+// MemoryPromptTemplateRepository keeps prompt templates in memory, for tests
+// and local development.
+type MemoryPromptTemplateRepository struct {
+	mu        sync.RWMutex
+	templates map[string]map[int]*models.PromptTemplate
+}
+
+func NewMemoryPromptTemplateRepository() *MemoryPromptTemplateRepository {
+	return &MemoryPromptTemplateRepository{templates: make(map[string]map[int]*models.PromptTemplate)}
+}
+
+var promptTemplateFile = regexp.MustCompile(`^(.+)-v(\d+)\.tmpl$`)
+
+// LoadPromptTemplates reads the <name>-v<version>.tmpl files of dir. A
+// missing directory has no templates.
+func LoadPromptTemplates(dir string) (*MemoryPromptTemplateRepository, error) {
+	r := NewMemoryPromptTemplateRepository()
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		match := promptTemplateFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+
+		version, _ := strconv.Atoi(match[2])
+		r.Put(&models.PromptTemplate{Name: match[1], Version: version, Template: string(data)})
+	}
+
+	return r, nil
+}
+
+func (r *MemoryPromptTemplateRepository) Put(template *models.PromptTemplate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.templates[template.Name] == nil {
+		r.templates[template.Name] = make(map[int]*models.PromptTemplate)
+	}
+	r.templates[template.Name][template.Version] = template
+}
+
+func (r *MemoryPromptTemplateRepository) GetPromptTemplate(ctx context.Context, name string, version int) (*models.PromptTemplate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if version == 0 {
+		for v := range r.templates[name] {
+			version = max(version, v)
+		}
+	}
+
+	template, ok := r.templates[name][version]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return template, nil
+}
+
+type cachedTriviaGrade struct {
+	grade     models.TriviaGrade
+	embedding []float32
+}
+
+// MemoryTriviaGradeCache keeps grades in memory, for tests and local
+// development.
+type MemoryTriviaGradeCache struct {
+	mu     sync.Mutex
+	grades map[TriviaGradeKey]cachedTriviaGrade
+}
+
+func NewMemoryTriviaGradeCache() *MemoryTriviaGradeCache {
+	return &MemoryTriviaGradeCache{grades: make(map[TriviaGradeKey]cachedTriviaGrade)}
+}
+
+func (c *MemoryTriviaGradeCache) Get(ctx context.Context, key TriviaGradeKey) (*models.TriviaGrade, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.grades[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &cached.grade, nil
+}
+
+func (c *MemoryTriviaGradeCache) Similar(ctx context.Context, key TriviaGradeKey, embedding []float32, minSimilarity float64) (*models.TriviaGrade, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var best *models.TriviaGrade
+	bestSimilarity := minSimilarity
+	for candidateKey, cached := range c.grades {
+		candidateKey.AnswerHash = key.AnswerHash
+		if candidateKey != key || cached.embedding == nil {
+			continue
+		}
+
+		if similarity := cosineSimilarity(embedding, cached.embedding); similarity >= bestSimilarity {
+			grade := cached.grade
+			best, bestSimilarity = &grade, similarity
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNotFound
+	}
+	return best, nil
+}
+
+func (c *MemoryTriviaGradeCache) Put(ctx context.Context, key TriviaGradeKey, grade *models.TriviaGrade, embedding []float32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.grades[key]; !ok {
+		c.grades[key] = cachedTriviaGrade{grade: *grade, embedding: embedding}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"octree.io-worker/internal/models"
+)
+
+type MongoProblemRepository struct {
+	collection *mongo.Collection
+	revisions  *mongo.Collection
+}
+
+func NewMongoProblemRepository(client *mongo.Client) *MongoProblemRepository {
+	database := client.Database("octree")
+	return &MongoProblemRepository{
+		collection: database.Collection("problems"),
+		revisions:  database.Collection("problem_revisions"),
+	}
+}
+
+func (r *MongoProblemRepository) GetByID(ctx context.Context, id int) (*models.Problem, error) {
+	return findProblem(ctx, r.collection, bson.M{"id": id}, id)
+}
+
+func (r *MongoProblemRepository) GetRevision(ctx context.Context, id int, version int) (*models.Problem, error) {
+	if version == 0 {
+		return r.GetByID(ctx, id)
+	}
+
+	filter := bson.M{"id": id, "version": version}
+
+	problem, err := findProblem(ctx, r.collection, filter, id)
+	if errors.Is(err, ErrNotFound) {
+		return findProblem(ctx, r.revisions, filter, id)
+	}
+	return problem, err
+}
+
+func (r *MongoProblemRepository) SaveRevision(ctx context.Context, problem *models.Problem) error {
+	filter := bson.M{"id": problem.ID, "version": problem.Version}
+	update := bson.M{"$setOnInsert": problem}
+
+	_, err := r.revisions.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save revision %d of problem %d: %w", problem.Version, problem.ID, err)
+	}
+
+	return nil
+}
+
+func findProblem(ctx context.Context, collection *mongo.Collection, filter bson.M, id int) (*models.Problem, error) {
+	result := collection.FindOne(ctx, filter)
+	if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if result.Err() != nil {
+		return nil, fmt.Errorf("failed to find problem by ID: %v", result.Err())
+	}
+
+	var problem models.Problem
+	if err := result.Decode(&problem); err != nil {
+		return nil, fmt.Errorf("%w %d: %v", models.ErrInvalidProblem, id, err)
+	}
+
+	if err := problem.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &problem, nil
+}
+
+// WatchProblems reports edits from a change stream. Change streams need a
+// replica set, so this fails right away on a standalone server.
+func (r *MongoProblemRepository) WatchProblems(ctx context.Context, onChange func(ProblemChange)) error {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+
+	stream, err := r.collection.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to watch problems: %w", err)
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var event struct {
+			FullDocument *struct {
+				ID int `bson:"id"`
+			} `bson:"fullDocument"`
+		}
+
+		// Deletes only carry the _id, so drop everything when the problem
+		// can't be identified.
+		if err := stream.Decode(&event); err != nil || event.FullDocument == nil {
+			onChange(ProblemChange{All: true})
+			continue
+		}
+
+		onChange(ProblemChange{ID: event.FullDocument.ID})
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := stream.Err(); err != nil {
+		return fmt.Errorf("problem change stream failed: %w", err)
+	}
+	return errors.New("problem change stream closed")
+}
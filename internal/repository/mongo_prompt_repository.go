@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"octree.io-worker/internal/models"
+)
+
+type MongoPromptTemplateRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoPromptTemplateRepository(client *mongo.Client) *MongoPromptTemplateRepository {
+	return &MongoPromptTemplateRepository{
+		collection: client.Database("octree").Collection("prompt_templates"),
+	}
+}
+
+func (r *MongoPromptTemplateRepository) GetPromptTemplate(ctx context.Context, name string, version int) (*models.PromptTemplate, error) {
+	filter := bson.M{"name": name}
+	if version != 0 {
+		filter["version"] = version
+	}
+
+	var template models.PromptTemplate
+	err := r.collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(&template)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find prompt template %s v%d: %w", name, version, err)
+	}
+
+	return &template, nil
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"octree.io-worker/internal/models"
+)
+
+type MongoTriviaQuestionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoTriviaQuestionRepository(client *mongo.Client) *MongoTriviaQuestionRepository {
+	return &MongoTriviaQuestionRepository{
+		collection: client.Database("octree").Collection("trivia_questions"),
+	}
+}
+
+func (r *MongoTriviaQuestionRepository) GetByIDs(ctx context.Context, ids []int) (map[int]*models.TriviaQuestion, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find trivia questions: %w", err)
+	}
+
+	var found []*models.TriviaQuestion
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("failed to decode trivia questions: %w", err)
+	}
+
+	return triviaQuestionsByID(found, ids)
+}
+
+func triviaQuestionsByID(found []*models.TriviaQuestion, ids []int) (map[int]*models.TriviaQuestion, error) {
+	questions := make(map[int]*models.TriviaQuestion, len(found))
+	for _, question := range found {
+		questions[question.ID] = question
+	}
+
+	for _, id := range ids {
+		if _, ok := questions[id]; !ok {
+			return nil, fmt.Errorf("trivia question %d: %w", id, ErrNotFound)
+		}
+	}
+
+	return questions, nil
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresHintLevelRepository struct {
+	pgPool *pgxpool.Pool
+}
+
+func NewPostgresHintLevelRepository(pgPool *pgxpool.Pool) *PostgresHintLevelRepository {
+	return &PostgresHintLevelRepository{pgPool: pgPool}
+}
+
+func (r *PostgresHintLevelRepository) NextHintLevel(ctx context.Context, user string, problemId int, maxLevel int) (int, error) {
+	// The upsert locks the row, so concurrent requests advance the level one
+	// after the other.
+	var level int
+	err := r.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO hint_levels (username, problem_id, level)
+		VALUES ($1, $2, LEAST(1, $3))
+		ON CONFLICT (username, problem_id) DO UPDATE
+		SET level = LEAST(hint_levels.level + 1, $3), updated_at = NOW()
+		RETURNING level`,
+		user, problemId, maxLevel,
+	).Scan(&level)
+	if err != nil {
+		return 0, fmt.Errorf("failed to advance hint level of problem %d: %w", problemId, err)
+	}
+
+	return level, nil
+}
+
+func (r *PostgresHintLevelRepository) UndoHintLevel(ctx context.Context, user string, problemId int, level int) error {
+	_, err := r.pgPool.Exec(
+		ctx,
+		`UPDATE hint_levels SET level = level - 1, updated_at = NOW()
+		WHERE username = $1 AND problem_id = $2 AND level = $3`,
+		user, problemId, level,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to undo hint level of problem %d: %w", problemId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"octree.io-worker/internal/models"
+)
+
+type PostgresSubmissionRepository struct {
+	pgPool *pgxpool.Pool
+}
+
+func NewPostgresSubmissionRepository(pgPool *pgxpool.Pool) *PostgresSubmissionRepository {
+	return &PostgresSubmissionRepository{pgPool: pgPool}
+}
+
+func (r *PostgresSubmissionRepository) Get(ctx context.Context, submissionId string) (*Submission, error) {
+	var submission Submission
+	var output string
+	err := r.pgPool.QueryRow(
+		ctx,
+		`SELECT submission_id, problem_id, language, code, type, COALESCE(room_id, ''), COALESCE(username, ''), COALESCE(status, ''), COALESCE(problem_version, 0), created_at, COALESCE(output::text, '')
+		FROM submissions WHERE submission_id=$1`, submissionId,
+	).Scan(submissionFields(&submission, &output)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up submission %s: %w", submissionId, err)
+	}
+
+	submission.Result = parseSubmissionResult(output)
+	return &submission, nil
+}
+
+// submissionFields are the scan targets for the columns selected by Get and
+// ListFinished.
+func submissionFields(submission *Submission, output *string) []interface{} {
+	return []interface{}{
+		&submission.SubmissionId,
+		&submission.ProblemId,
+		&submission.Language,
+		&submission.Code,
+		&submission.RunType,
+		&submission.RoomId,
+		&submission.Username,
+		&submission.Status,
+		&submission.ProblemVersion,
+		&submission.CreatedAt,
+		output,
+	}
+}
+
+func parseSubmissionResult(output string) *models.SubmissionResult {
+	if output == "" {
+		return nil
+	}
+
+	var result models.SubmissionResult
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		return &models.SubmissionResult{LegacyOutput: output}
+	}
+	return &result
+}
+
+func (r *PostgresSubmissionRepository) Claim(ctx context.Context, submissionId string, owner string, lease time.Duration) (*Submission, error) {
+	claimQuery := `
+    UPDATE submissions
+		SET status = 'RUNNING', lease_owner = $2, lease_expires_at = NOW() + make_interval(secs => $3), heartbeat_at = NOW()
+		WHERE submission_id = $1
+			AND (status = 'PENDING' OR (status = 'RUNNING' AND (lease_expires_at IS NULL OR lease_expires_at < NOW())))
+		RETURNING problem_id, language, code, type, COALESCE(room_id, ''), COALESCE(username, ''), COALESCE(problem_version, 0);
+  `
+
+	submission := Submission{SubmissionId: submissionId, Status: "RUNNING"}
+	err := r.pgPool.QueryRow(ctx, claimQuery, submissionId, owner, lease.Seconds()).Scan(
+		&submission.ProblemId,
+		&submission.Language,
+		&submission.Code,
+		&submission.RunType,
+		&submission.RoomId,
+		&submission.Username,
+		&submission.ProblemVersion,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var status string
+		err = r.pgPool.QueryRow(ctx, "SELECT status FROM submissions WHERE submission_id=$1", submissionId).Scan(&status)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up submission: %w", err)
+		}
+		if status == "RUNNING" {
+			return nil, ErrSubmissionLeaseHeld
+		}
+		return nil, ErrSubmissionAlreadyProcessed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim submission: %w", err)
+	}
+
+	return &submission, nil
+}
+
+func (r *PostgresSubmissionRepository) LeaseExpiry(ctx context.Context, submissionId string) (*time.Time, error) {
+	var expiresAt *time.Time
+	err := r.pgPool.QueryRow(ctx, "SELECT lease_expires_at FROM submissions WHERE submission_id=$1", submissionId).Scan(&expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lease: %w", err)
+	}
+
+	return expiresAt, nil
+}
+
+func (r *PostgresSubmissionRepository) ExtendLease(ctx context.Context, submissionId string, owner string, lease time.Duration) (bool, error) {
+	tag, err := r.pgPool.Exec(
+		ctx,
+		`UPDATE submissions
+		SET lease_expires_at = NOW() + make_interval(secs => $3), heartbeat_at = NOW()
+		WHERE submission_id = $1 AND lease_owner = $2 AND status = 'RUNNING'`,
+		submissionId, owner, lease.Seconds(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to extend lease: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *PostgresSubmissionRepository) Complete(ctx context.Context, submissionId string, owner string, result *models.SubmissionResult, status string) (bool, error) {
+	return r.saveResult(
+		ctx, submissionId, result, status,
+		"lease_owner = $5 AND status = 'RUNNING'", owner,
+	)
+}
+
+func (r *PostgresSubmissionRepository) SaveRejudge(ctx context.Context, submissionId string, result *models.SubmissionResult, status string) (bool, error) {
+	return r.saveResult(
+		ctx, submissionId, result, status,
+		"status IN ('SUCCEEDED', 'FAILED')",
+	)
+}
+
+// saveResult writes the result and its test case rows if the submission
+// matches condition, and reports whether it did. The problem version the
+// result was judged against is stored unless it is 0. Placeholders in
+// condition start at $5.
+func (r *PostgresSubmissionRepository) saveResult(ctx context.Context, submissionId string, result *models.SubmissionResult, status string, condition string, args ...interface{}) (bool, error) {
+	output, err := json.Marshal(result)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal submission result: %w", err)
+	}
+
+	updateQuery := `
+    UPDATE submissions
+		SET output = $1::jsonb, status = $2, problem_version = COALESCE(NULLIF($3, 0), problem_version), lease_expires_at = NULL
+		WHERE submission_id = $4 AND ` + condition + `
+		RETURNING problem_id;
+  `
+
+	saved := false
+	err = pgx.BeginFunc(ctx, r.pgPool, func(tx pgx.Tx) error {
+		var problemId int
+		queryArgs := append([]interface{}{string(output), status, result.ProblemVersion, submissionId}, args...)
+		err := tx.QueryRow(ctx, updateQuery, queryArgs...).Scan(&problemId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		saved = true
+
+		if _, err := tx.Exec(ctx, "DELETE FROM submission_test_results WHERE submission_id = $1", submissionId); err != nil {
+			return err
+		}
+
+		batch := &pgx.Batch{}
+		for _, testResult := range result.TestResults {
+			batch.Queue(
+				`INSERT INTO submission_test_results (submission_id, problem_id, case_index, verdict, output_hash)
+				VALUES ($1, $2, $3, $4, NULLIF($5, ''))`,
+				submissionId, problemId, testResult.Index, testResult.Verdict, testResult.OutputHash,
+			)
+		}
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to update submission: %w", err)
+	}
+
+	return saved, nil
+}
+
+func (r *PostgresSubmissionRepository) ListFinished(ctx context.Context, filter SubmissionFilter) ([]*Submission, error) {
+	query := `SELECT submission_id, problem_id, language, code, type, COALESCE(room_id, ''), COALESCE(username, ''), COALESCE(status, ''), COALESCE(problem_version, 0), created_at, COALESCE(output::text, '')
+		FROM submissions WHERE status IN ('SUCCEEDED', 'FAILED')`
+	var args []interface{}
+
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		query += fmt.Sprintf(" AND "+condition, len(args))
+	}
+	if filter.ProblemId != 0 {
+		where("problem_id = $%d", filter.ProblemId)
+	}
+	if filter.RoomId != "" {
+		where("room_id = $%d", filter.RoomId)
+	}
+	if filter.Language != "" {
+		where("language = $%d", filter.Language)
+	}
+	if filter.RunType != "" {
+		where("type = $%d", filter.RunType)
+	}
+	if !filter.Since.IsZero() {
+		where("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("created_at < $%d", filter.Until)
+	}
+	query += " ORDER BY created_at, submission_id"
+
+	rows, err := r.pgPool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list submissions: %w", err)
+	}
+	defer rows.Close()
+
+	var submissions []*Submission
+	for rows.Next() {
+		var submission Submission
+		var output string
+		if err := rows.Scan(submissionFields(&submission, &output)...); err != nil {
+			return nil, fmt.Errorf("failed to read submission: %w", err)
+		}
+		submission.Result = parseSubmissionResult(output)
+		submissions = append(submissions, &submission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list submissions: %w", err)
+	}
+
+	return submissions, nil
+}
+
+func (r *PostgresSubmissionRepository) Requeue(ctx context.Context, submissionId string, version int) (bool, error) {
+	tag, err := r.pgPool.Exec(
+		ctx,
+		`UPDATE submissions
+		SET status = 'PENDING', problem_version = NULLIF($2, 0), lease_owner = NULL, lease_expires_at = NULL
+		WHERE submission_id = $1 AND status IN ('SUCCEEDED', 'FAILED')`,
+		submissionId, version,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to requeue submission: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"octree.io-worker/internal/models"
+)
+
+type PostgresTriviaSubmissionRepository struct {
+	pgPool *pgxpool.Pool
+}
+
+func NewPostgresTriviaSubmissionRepository(pgPool *pgxpool.Pool) *PostgresTriviaSubmissionRepository {
+	return &PostgresTriviaSubmissionRepository{pgPool: pgPool}
+}
+
+func (r *PostgresTriviaSubmissionRepository) Save(ctx context.Context, submission *TriviaSubmission) error {
+	grades, err := json.Marshal(append([]models.TriviaGrade{}, submission.Grades...))
+	if err != nil {
+		return fmt.Errorf("failed to marshal trivia grades: %w", err)
+	}
+
+	_, err = r.pgPool.Exec(
+		ctx,
+		`INSERT INTO trivia_submissions
+			(submission_id, room_id, username, status, grades, score, passed, questions, error, graded_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5::jsonb, $6, $7, $8, NULLIF($9, ''), $10)
+		ON CONFLICT (submission_id) DO UPDATE
+		SET room_id = EXCLUDED.room_id, username = EXCLUDED.username, status = EXCLUDED.status,
+			grades = EXCLUDED.grades, score = EXCLUDED.score, passed = EXCLUDED.passed,
+			questions = EXCLUDED.questions, error = EXCLUDED.error, graded_at = EXCLUDED.graded_at`,
+		submission.SubmissionId, submission.RoomId, submission.Username, submission.Status, string(grades),
+		submission.Score, submission.Passed, submission.Questions, submission.Error, submission.GradedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save trivia submission %s: %w", submission.SubmissionId, err)
+	}
+
+	return nil
+}
+
+// similarTriviaGradeCandidates bounds the cached answers compared with an
+// answer, most reused first.
+const similarTriviaGradeCandidates = 500
+
+type PostgresTriviaGradeCache struct {
+	pgPool *pgxpool.Pool
+}
+
+func NewPostgresTriviaGradeCache(pgPool *pgxpool.Pool) *PostgresTriviaGradeCache {
+	return &PostgresTriviaGradeCache{pgPool: pgPool}
+}
+
+func (c *PostgresTriviaGradeCache) Get(ctx context.Context, key TriviaGradeKey) (*models.TriviaGrade, error) {
+	var grade models.TriviaGrade
+	err := c.pgPool.QueryRow(
+		ctx,
+		`UPDATE trivia_grade_cache SET hits = hits + 1
+		WHERE question_id = $1 AND rubric_version = $2 AND prompt_version = $3 AND prompt_hash = $4 AND model = $5 AND answer_hash = $6
+		RETURNING grade`,
+		key.QuestionId, key.RubricVersion, key.PromptVersion, key.PromptHash, key.Model, key.AnswerHash,
+	).Scan(&grade)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached trivia grade: %w", err)
+	}
+
+	return &grade, nil
+}
+
+func (c *PostgresTriviaGradeCache) Similar(ctx context.Context, key TriviaGradeKey, embedding []float32, minSimilarity float64) (*models.TriviaGrade, error) {
+	rows, err := c.pgPool.Query(
+		ctx,
+		`SELECT answer_hash, grade, embedding FROM trivia_grade_cache
+		WHERE question_id = $1 AND rubric_version = $2 AND prompt_version = $3 AND prompt_hash = $4 AND model = $5 AND embedding IS NOT NULL
+		ORDER BY hits DESC
+		LIMIT $6`,
+		key.QuestionId, key.RubricVersion, key.PromptVersion, key.PromptHash, key.Model, similarTriviaGradeCandidates,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find similar trivia grades: %w", err)
+	}
+	defer rows.Close()
+
+	var best *models.TriviaGrade
+	var bestHash string
+	bestSimilarity := minSimilarity
+	for rows.Next() {
+		var answerHash string
+		var grade models.TriviaGrade
+		var candidate []float32
+		if err := rows.Scan(&answerHash, &grade, &candidate); err != nil {
+			return nil, fmt.Errorf("failed to scan trivia grade: %w", err)
+		}
+
+		if similarity := cosineSimilarity(embedding, candidate); similarity >= bestSimilarity {
+			best, bestHash, bestSimilarity = &grade, answerHash, similarity
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find similar trivia grades: %w", err)
+	}
+	if best == nil {
+		return nil, ErrNotFound
+	}
+
+	_, err = c.pgPool.Exec(
+		ctx,
+		`UPDATE trivia_grade_cache SET hits = hits + 1
+		WHERE question_id = $1 AND rubric_version = $2 AND prompt_version = $3 AND prompt_hash = $4 AND model = $5 AND answer_hash = $6`,
+		key.QuestionId, key.RubricVersion, key.PromptVersion, key.PromptHash, key.Model, bestHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count trivia grade hit: %w", err)
+	}
+
+	return best, nil
+}
+
+func (c *PostgresTriviaGradeCache) Put(ctx context.Context, key TriviaGradeKey, grade *models.TriviaGrade, embedding []float32) error {
+	data, err := json.Marshal(grade)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trivia grade: %w", err)
+	}
+
+	_, err = c.pgPool.Exec(
+		ctx,
+		`INSERT INTO trivia_grade_cache (question_id, rubric_version, prompt_version, prompt_hash, model, answer_hash, grade, embedding)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8)
+		ON CONFLICT DO NOTHING`,
+		key.QuestionId, key.RubricVersion, key.PromptVersion, key.PromptHash, key.Model, key.AnswerHash, string(data), embedding,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cache trivia grade: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"octree.io-worker/internal/models"
+)
+
+// RedisTriviaGradeCache keeps grades under octree:trivia-grades keys that
+// expire after ttl. It doesn't store embeddings, so Similar never finds a
+// grade.
+type RedisTriviaGradeCache struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+func NewRedisTriviaGradeCache(rdb *redis.Client, ttl time.Duration) *RedisTriviaGradeCache {
+	return &RedisTriviaGradeCache{rdb: rdb, ttl: ttl}
+}
+
+func triviaGradeCacheKey(key TriviaGradeKey) string {
+	return fmt.Sprintf("octree:trivia-grades:%d:%d:%d:%s:%s:%s", key.QuestionId, key.RubricVersion, key.PromptVersion, key.PromptHash, key.Model, key.AnswerHash)
+}
+
+func (c *RedisTriviaGradeCache) Get(ctx context.Context, key TriviaGradeKey) (*models.TriviaGrade, error) {
+	data, err := c.rdb.Get(ctx, triviaGradeCacheKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached trivia grade: %w", err)
+	}
+
+	var grade models.TriviaGrade
+	if err := json.Unmarshal(data, &grade); err != nil {
+		return nil, fmt.Errorf("failed to parse cached trivia grade: %w", err)
+	}
+	return &grade, nil
+}
+
+func (c *RedisTriviaGradeCache) Similar(ctx context.Context, key TriviaGradeKey, embedding []float32, minSimilarity float64) (*models.TriviaGrade, error) {
+	return nil, ErrNotFound
+}
+
+func (c *RedisTriviaGradeCache) Put(ctx context.Context, key TriviaGradeKey, grade *models.TriviaGrade, embedding []float32) error {
+	data, err := json.Marshal(grade)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trivia grade: %w", err)
+	}
+
+	if err := c.rdb.SetNX(ctx, triviaGradeCacheKey(key), data, c.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to cache trivia grade: %w", err)
+	}
+	return nil
+}
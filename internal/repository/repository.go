@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"octree.io-worker/internal/models"
+)
+
+var (
+	ErrNotFound                   = errors.New("not found")
+	ErrSubmissionAlreadyProcessed = errors.New("submission was already processed")
+	ErrSubmissionLeaseHeld        = errors.New("submission is running on a live worker")
+)
+
+type Submission struct {
+	SubmissionId string
+	ProblemId    int
+	Language     string
+	Code         string
+	RunType      string
+	RoomId       string
+	Username     string
+	Status       string
+
+	// ProblemVersion pins the problem revision the submission is judged
+	// against; 0 judges against the current problem.
+	ProblemVersion int
+	CreatedAt      time.Time
+
+	// Result is nil until the submission finished.
+	Result *models.SubmissionResult
+}
+
+// SubmissionRepository stores submissions and the leases workers hold on them
+// while they run.
+type SubmissionRepository interface {
+	Get(ctx context.Context, submissionId string) (*Submission, error)
+
+	// Claim moves a submission from PENDING to RUNNING, or takes over a
+	// RUNNING submission whose lease expired, and makes owner the lease
+	// holder. It returns ErrSubmissionLeaseHeld while another owner holds a
+	// valid lease, and ErrSubmissionAlreadyProcessed once the submission
+	// finished.
+	Claim(ctx context.Context, submissionId string, owner string, lease time.Duration) (*Submission, error)
+
+	// LeaseExpiry returns when the current lease expires, or nil if the
+	// submission has no lease.
+	LeaseExpiry(ctx context.Context, submissionId string) (*time.Time, error)
+
+	// ExtendLease reports false when owner no longer holds the lease.
+	ExtendLease(ctx context.Context, submissionId string, owner string, lease time.Duration) (bool, error)
+
+	// Complete writes the final result and reports false, without writing,
+	// when owner no longer holds the lease.
+	Complete(ctx context.Context, submissionId string, owner string, result *models.SubmissionResult, status string) (bool, error)
+
+	// ListFinished returns the SUCCEEDED and FAILED submissions matching
+	// filter.
+	ListFinished(ctx context.Context, filter SubmissionFilter) ([]*Submission, error)
+
+	// SaveRejudge replaces the result of a finished submission and reports
+	// false, without writing, when it isn't finished.
+	SaveRejudge(ctx context.Context, submissionId string, result *models.SubmissionResult, status string) (bool, error)
+
+	// Requeue moves a finished submission back to PENDING, pinned to a
+	// problem version or unpinned for version 0, and reports false when it
+	// isn't finished.
+	Requeue(ctx context.Context, submissionId string, version int) (bool, error)
+}
+
+// SubmissionFilter selects submissions; zero fields match everything. Since
+// is inclusive and Until exclusive.
+type SubmissionFilter struct {
+	ProblemId int
+	RoomId    string
+	Language  string
+	RunType   string
+	Since     time.Time
+	Until     time.Time
+}
+
+type ProblemRepository interface {
+	// GetByID returns a validated problem, or an error wrapping
+	// models.ErrInvalidProblem when the stored document is malformed.
+	GetByID(ctx context.Context, id int) (*models.Problem, error)
+
+	// GetRevision returns a version of a problem saved by SaveRevision, or
+	// the current problem if it has that version. Version 0 is the current
+	// problem.
+	GetRevision(ctx context.Context, id int, version int) (*models.Problem, error)
+
+	// SaveRevision keeps a copy of the problem's current version, so
+	// submissions judged against it can be judged against it again after
+	// the problem is edited. Saving a version twice keeps the first copy.
+	SaveRevision(ctx context.Context, problem *models.Problem) error
+}
+
+// ProblemChange identifies an edited problem. All is set when the problem
+// can't be identified, e.g. because it was deleted.
+type ProblemChange struct {
+	ID  int
+	All bool
+}
+
+// ProblemWatcher is implemented by problem repositories that can push edits
+// to caches. WatchProblems blocks until ctx is done, or returns an error when
+// watching is unsupported or fails.
+type ProblemWatcher interface {
+	WatchProblems(ctx context.Context, onChange func(ProblemChange)) error
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"octree.io-worker/internal/models"
+)
+
+type TriviaQuestionRepository interface {
+	// GetByIDs returns the questions with the given IDs, and ErrNotFound if
+	// any of them doesn't exist.
+	GetByIDs(ctx context.Context, ids []int) (map[int]*models.TriviaQuestion, error)
+}
+
+// TriviaSubmission is a graded trivia submission. Score is the sum of the
+// grades' scores and Passed the number of passing answers; failed gradings
+// have no grades and an Error.
+type TriviaSubmission struct {
+	SubmissionId string
+	RoomId       string
+	Username     string
+	Status       string
+	Grades       []models.TriviaGrade
+	Score        int
+	Passed       int
+	Questions    int
+	Error        string
+	GradedAt     time.Time
+}
+
+type TriviaSubmissionRepository interface {
+	// Save stores a graded submission, replacing an earlier grading of it.
+	Save(ctx context.Context, submission *TriviaSubmission) error
+}
+
+type PromptTemplateRepository interface {
+	// GetPromptTemplate returns version of the named template, the latest one
+	// when version is 0, and ErrNotFound if there is none.
+	GetPromptTemplate(ctx context.Context, name string, version int) (*models.PromptTemplate, error)
+}
+
+// TriviaGradeKey identifies the answers that get the same grade: the same
+// normalized answer to a question, graded by the same model with the same
+// rubric and prompt. PromptHash identifies the text of the prompt template,
+// which changes without its version when the built-in template does.
+type TriviaGradeKey struct {
+	QuestionId    int
+	RubricVersion int
+	PromptVersion int
+	PromptHash    string
+	Model         string
+	AnswerHash    string
+}
+
+type TriviaGradeCache interface {
+	// Get returns the grade cached for key, and ErrNotFound if there is none.
+	Get(ctx context.Context, key TriviaGradeKey) (*models.TriviaGrade, error)
+
+	// Similar returns the grade of the answer whose embedding is the most
+	// similar to embedding, among those with the question, rubric, prompt and
+	// model of key, if their cosine similarity is at least minSimilarity. It returns
+	// ErrNotFound otherwise.
+	Similar(ctx context.Context, key TriviaGradeKey, embedding []float32, minSimilarity float64) (*models.TriviaGrade, error)
+
+	// Put caches a grade, with the answer's embedding if it has one.
+	Put(ctx context.Context, key TriviaGradeKey, grade *models.TriviaGrade, embedding []float32) error
+}
+
+func cosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
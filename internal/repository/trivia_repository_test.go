@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"octree.io-worker/internal/models"
+)
+
+func TestTriviaGradeCaches(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		cache func(t *testing.T) TriviaGradeCache
+	}{
+		{"memory", func(t *testing.T) TriviaGradeCache { return NewMemoryTriviaGradeCache() }},
+		{"redis", func(t *testing.T) TriviaGradeCache {
+			rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			t.Cleanup(func() { rdb.Close() })
+			return NewRedisTriviaGradeCache(rdb, time.Hour)
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			cache := test.cache(t)
+			key := TriviaGradeKey{QuestionId: 1, RubricVersion: 2, PromptVersion: 3, PromptHash: "template", Model: "gpt-4o-mini", AnswerHash: "answer"}
+
+			if _, err := cache.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get before Put = %v, want ErrNotFound", err)
+			}
+
+			if err := cache.Put(ctx, key, &models.TriviaGrade{QuestionId: 1, Score: 80, Pass: true}, nil); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			// The first grade of an answer is kept.
+			if err := cache.Put(ctx, key, &models.TriviaGrade{QuestionId: 1, Score: 10}, nil); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			grade, err := cache.Get(ctx, key)
+			if err != nil || grade.Score != 80 || !grade.Pass {
+				t.Fatalf("Get = %+v, %v, want the first grade", grade, err)
+			}
+
+			for name, other := range map[string]TriviaGradeKey{
+				"another model":          {QuestionId: 1, RubricVersion: 2, PromptVersion: 3, PromptHash: "template", Model: "gpt-4o", AnswerHash: "answer"},
+				"an edited template":     {QuestionId: 1, RubricVersion: 2, PromptVersion: 3, PromptHash: "edited", Model: "gpt-4o-mini", AnswerHash: "answer"},
+				"another prompt":         {QuestionId: 1, RubricVersion: 2, PromptVersion: 4, PromptHash: "template", Model: "gpt-4o-mini", AnswerHash: "answer"},
+				"another rubric":         {QuestionId: 1, RubricVersion: 1, PromptVersion: 3, PromptHash: "template", Model: "gpt-4o-mini", AnswerHash: "answer"},
+				"another answer":         {QuestionId: 1, RubricVersion: 2, PromptVersion: 3, PromptHash: "template", Model: "gpt-4o-mini", AnswerHash: "other"},
+				"another question's key": {QuestionId: 2, RubricVersion: 2, PromptVersion: 3, PromptHash: "template", Model: "gpt-4o-mini", AnswerHash: "answer"},
+			} {
+				if _, err := cache.Get(ctx, other); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get with %s = %v, want ErrNotFound", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryTriviaGradeCacheSimilar(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryTriviaGradeCache()
+	key := TriviaGradeKey{QuestionId: 1, PromptHash: "template", Model: "gpt-4o-mini"}
+
+	put := func(answerHash string, model string, score int, embedding []float32) {
+		k := key
+		k.AnswerHash, k.Model = answerHash, model
+		if err := cache.Put(ctx, k, &models.TriviaGrade{Score: score}, embedding); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	put("close", "gpt-4o-mini", 90, []float32{1, 0.1})
+	put("far", "gpt-4o-mini", 10, []float32{0, 1})
+	put("closest by another model", "gpt-4o", 50, []float32{1, 0})
+	put("without embedding", "gpt-4o-mini", 70, nil)
+
+	grade, err := cache.Similar(ctx, key, []float32{1, 0}, 0.9)
+	if err != nil || grade.Score != 90 {
+		t.Errorf("Similar = %+v, %v, want the closest grade by the same model", grade, err)
+	}
+	if _, err := cache.Similar(ctx, key, []float32{-1, 0}, 0.9); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Similar without a similar answer = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRedisTriviaGradeCache(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+	cache := NewRedisTriviaGradeCache(rdb, time.Hour)
+
+	key := TriviaGradeKey{QuestionId: 1, AnswerHash: "answer"}
+	if err := cache.Put(ctx, key, &models.TriviaGrade{Score: 90}, []float32{1, 0}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := cache.Similar(ctx, key, []float32{1, 0}, 0.5); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Similar = %v, want ErrNotFound since Redis doesn't store embeddings", err)
+	}
+
+	server.FastForward(2 * time.Hour)
+	if _, err := cache.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after the TTL = %v, want ErrNotFound", err)
+	}
+}
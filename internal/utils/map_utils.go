@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -63,3 +64,31 @@ func bsonDToMap(d primitive.D) map[string]interface{} {
 	}
 	return result
 }
+
+// ConvertJSONNumbers replaces the json.Number values produced by a decoder with
+// UseNumber by int64 or float64, matching the integers the harnesses expect
+// from problems loaded from MongoDB.
+func ConvertJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, elem := range v {
+			result[i] = ConvertJSONNumbers(elem)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			result[key] = ConvertJSONNumbers(val)
+		}
+		return result
+	default:
+		return v
+	}
+}
@@ -0,0 +1,129 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"octree.io-worker/internal/broker"
+)
+
+const CompilationControlExchange = "compilation_control"
+
+// pendingCancellationTTL is how long a cancel for a submission that hasn't
+// started yet is remembered, so it is still cancelled when a worker picks it up.
+const pendingCancellationTTL = 10 * time.Minute
+
+// CancelMessage is published to the compilation_control exchange. It cancels
+// one submission by SubmissionId, or every running submission of a socket by
+// SocketId, e.g. when the user leaves a room.
+type CancelMessage struct {
+	SubmissionId string `json:"submissionId,omitempty"`
+	SocketId     string `json:"socketId,omitempty"`
+}
+
+type runningSubmission struct {
+	socketId string
+	cancel   context.CancelFunc
+}
+
+type cancellationRegistry struct {
+	mu      sync.Mutex
+	running map[string][]*runningSubmission
+	pending map[string]time.Time
+}
+
+var cancellations = &cancellationRegistry{
+	running: make(map[string][]*runningSubmission),
+	pending: make(map[string]time.Time),
+}
+
+// Register returns a context that is cancelled when a cancel message for the
+// submission arrives. release must be called once the submission is done.
+func (r *cancellationRegistry) Register(submissionId string, socketId string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.pending[submissionId]; ok {
+		delete(r.pending, submissionId)
+		cancel()
+	}
+	running := &runningSubmission{socketId: socketId, cancel: cancel}
+	r.running[submissionId] = append(r.running[submissionId], running)
+
+	// Other registrations of the submission, e.g. inline requests sharing a
+	// correlation id, stay cancellable.
+	release := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		registered := slices.DeleteFunc(r.running[submissionId], func(other *runningSubmission) bool {
+			return other == running
+		})
+		if len(registered) == 0 {
+			delete(r.running, submissionId)
+		} else {
+			r.running[submissionId] = registered
+		}
+		cancel()
+	}
+
+	return ctx, release
+}
+
+func (r *cancellationRegistry) Cancel(message CancelMessage) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cancelled := 0
+
+	if message.SubmissionId != "" {
+		if registered, ok := r.running[message.SubmissionId]; ok {
+			for _, running := range registered {
+				running.cancel()
+				cancelled++
+			}
+		} else {
+			r.pending[message.SubmissionId] = time.Now()
+		}
+	}
+
+	if message.SocketId != "" {
+		for _, registered := range r.running {
+			for _, running := range registered {
+				if running.socketId == message.SocketId {
+					running.cancel()
+					cancelled++
+				}
+			}
+		}
+	}
+
+	for submissionId, cancelledAt := range r.pending {
+		if time.Since(cancelledAt) > pendingCancellationTTL {
+			delete(r.pending, submissionId)
+		}
+	}
+
+	return cancelled
+}
+
+// SpawnControlListener applies cancel messages from the control topic, which
+// every worker process subscribes to.
+func SpawnControlListener(msgs <-chan broker.Delivery) {
+	for msg := range msgs {
+		var message CancelMessage
+		if err := json.Unmarshal(msg.Message().Body, &message); err != nil {
+			log.Printf("[Control Listener] Failed to parse message to JSON: %v", err)
+			continue
+		}
+
+		cancelled := cancellations.Cancel(message)
+		log.Printf("[Control Listener] Cancel for submission %q socket %q stopped %d running submission(s)", message.SubmissionId, message.SocketId, cancelled)
+	}
+}
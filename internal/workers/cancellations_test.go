@@ -0,0 +1,31 @@
+package workers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCancellationRegistryDuplicateRelease(t *testing.T) {
+	registry := &cancellationRegistry{
+		running: make(map[string][]*runningSubmission),
+		pending: make(map[string]time.Time),
+	}
+
+	running, releaseRunning := registry.Register("s1", "socket-1")
+	defer releaseRunning()
+
+	// A duplicate registration that ends first leaves the running one
+	// cancellable.
+	_, releaseDuplicate := registry.Register("s1", "socket-1")
+	releaseDuplicate()
+
+	if cancelled := registry.Cancel(CancelMessage{SubmissionId: "s1"}); cancelled != 1 {
+		t.Errorf("Cancel stopped %d submissions, want 1", cancelled)
+	}
+	if running.Err() == nil {
+		t.Error("the running submission wasn't cancelled")
+	}
+	if len(registry.pending) != 0 {
+		t.Errorf("pending = %v, want the cancel applied rather than kept", registry.pending)
+	}
+}
@@ -0,0 +1,335 @@
+package workers
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/utils"
+)
+
+const (
+	codeReviewRequestsQueue = "code_review_requests"
+	CodeReviewsQueue        = "code_reviews"
+
+	EventCodeReview = "CODE_REVIEW"
+)
+
+// CodeReviewCase is the first failing test case of a reviewed submission.
+// Submissions are judged against hidden test cases, so only the case's index
+// and verdict are given, never its input or output.
+type CodeReviewCase struct {
+	Index   int    `json:"index"`
+	Verdict string `json:"verdict"`
+}
+
+// CodeReviewRequestMessage is a judged submission to review, published by the
+// compilation workers when CODE_REVIEW_ENABLED is set.
+type CodeReviewRequestMessage struct {
+	SubmissionId     string          `json:"submissionId"`
+	SocketId         string          `json:"socketId"`
+	RoomId           string          `json:"roomId"`
+	Username         string          `json:"username"`
+	ProblemId        int             `json:"problemId"`
+	ProblemTitle     string          `json:"problemTitle"`
+	ProblemStatement string          `json:"problemStatement"`
+	Language         string          `json:"language"`
+	Code             string          `json:"code"`
+	Verdict          string          `json:"verdict"`
+	FailingCase      *CodeReviewCase `json:"failingCase,omitempty"`
+	Stderr           string          `json:"stderr,omitempty"`
+}
+
+// CodeReviewMessage is published to code_reviews with the CODE_REVIEW event.
+// Status is SUCCEEDED, FAILED, or RATE_LIMITED when the user asked for too
+// many reviews.
+type CodeReviewMessage struct {
+	SubmissionId string             `json:"submissionId"`
+	SocketId     string             `json:"socketId"`
+	RoomId       string             `json:"roomId"`
+	Username     string             `json:"username"`
+	Verdict      string             `json:"verdict"`
+	Status       string             `json:"status"`
+	Review       *models.CodeReview `json:"review,omitempty"`
+	Error        string             `json:"error,omitempty"`
+	Event        string             `json:"event"`
+}
+
+// CodeReviewer is implemented by graders that can review code.
+type CodeReviewer interface {
+	Review(ctx context.Context, request CodeReviewRequestMessage) (models.CodeReview, error)
+}
+
+// CodeReviewDeps are the services the code review pipeline depends on.
+// Reviewer defaults to the grader LoadLLMConfig selects.
+type CodeReviewDeps struct {
+	Broker   broker.Broker
+	Reviewer CodeReviewer
+
+	limiter *userRateLimiter
+}
+
+func codeReviewsEnabled() bool {
+	return utils.GetEnvBool("CODE_REVIEW_ENABLED", false)
+}
+
+// newCodeReviewRequest describes a judged submission for review, with the
+// index of its first failing test case.
+func newCodeReviewRequest(job *compilationJob, data *testData, result *models.SubmissionResult) CodeReviewRequestMessage {
+	request := CodeReviewRequestMessage{
+		SubmissionId:     job.SubmissionId,
+		SocketId:         job.SocketId,
+		RoomId:           job.RoomId,
+		Username:         job.Username,
+		ProblemId:        job.ProblemId,
+		ProblemTitle:     data.Title,
+		ProblemStatement: data.Description,
+		Language:         job.Language,
+		Code:             job.Code,
+		Verdict:          result.Verdict,
+		Stderr:           result.Stderr,
+	}
+
+	for _, testResult := range result.TestResults {
+		if testResult.Passed {
+			continue
+		}
+
+		request.FailingCase = &CodeReviewCase{Index: testResult.Index, Verdict: testResult.Verdict}
+		break
+	}
+
+	return request
+}
+
+// requestCodeReview queues a review of a judged submission. Only submissions
+// of type submit with a verdict from the judge are reviewed.
+func requestCodeReview(b broker.Broker, job *compilationJob, data *testData, result *models.SubmissionResult) {
+	if job.RunType != "submit" || result.Verdict == models.VerdictError || result.Verdict == models.VerdictCancelled {
+		return
+	}
+
+	if err := publishResponse(b, codeReviewRequestsQueue, "", newCodeReviewRequest(job, data, result)); err != nil {
+		log.Printf("Failed to request a code review of submission %s: %v", job.SubmissionId, err)
+	}
+}
+
+// userRateLimiter allows each user limit events per window.
+type userRateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string][]time.Time
+}
+
+func newUserRateLimiter(limit int, window time.Duration) *userRateLimiter {
+	return &userRateLimiter{limit: limit, window: window, seen: make(map[string][]time.Time)}
+}
+
+// Allow records an event for user and reports whether it is within the
+// limit. A limit of 0 allows every event.
+func (l *userRateLimiter) Allow(user string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	recent := l.seen[user][:0]
+	for _, at := range l.seen[user] {
+		if now.Sub(at) < l.window {
+			recent = append(recent, at)
+		}
+	}
+
+	if len(recent) >= l.limit {
+		l.seen[user] = recent
+		return false
+	}
+
+	l.seen[user] = append(recent, now)
+	return true
+}
+
+// prune forgets the users without events in the last window.
+func (l *userRateLimiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for user, times := range l.seen {
+		if now.Sub(times[len(times)-1]) >= l.window {
+			delete(l.seen, user)
+		}
+	}
+}
+
+// pruneEvery prunes the limiter every interval until ctx is done, so it only
+// keeps the users that were recently active.
+func (l *userRateLimiter) pruneEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.prune()
+		}
+	}
+}
+
+func processCodeReviewRequest(deps *CodeReviewDeps, msg broker.Delivery) {
+	var request CodeReviewRequestMessage
+	if err := json.Unmarshal(msg.Message().Body, &request); err != nil {
+		log.Printf("Invalid code review request: %v", err)
+		return
+	}
+
+	response := CodeReviewMessage{
+		SubmissionId: request.SubmissionId,
+		SocketId:     request.SocketId,
+		RoomId:       request.RoomId,
+		Username:     request.Username,
+		Verdict:      request.Verdict,
+		Status:       "SUCCEEDED",
+		Event:        EventCodeReview,
+	}
+
+	// Anonymous submissions are limited per socket.
+	user := request.Username
+	if user == "" {
+		user = "socket:" + request.SocketId
+	}
+
+	if !deps.limiter.Allow(user) {
+		log.Printf("Code review of submission %s is rate limited for %s", request.SubmissionId, user)
+		response.Status = "RATE_LIMITED"
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), utils.GetEnvDuration("CODE_REVIEW_TIMEOUT", 2*time.Minute))
+		review, err := deps.Reviewer.Review(ctx, request)
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to review submission %s: %v", request.SubmissionId, err)
+			response.Status = "FAILED"
+			response.Error = "review failed"
+		} else {
+			response.Review = &review
+		}
+	}
+
+	if err := publishResponse(deps.Broker, CodeReviewsQueue, "", response); err != nil {
+		log.Printf("Failed to send a code review message: %v", err)
+	}
+}
+
+func SpawnCodeReviewWorker(id int, deps *CodeReviewDeps, msgs <-chan broker.Delivery) {
+	for msg := range msgs {
+		log.Printf("[Code Review Worker %d] Received a request", id)
+
+		processCodeReviewRequest(deps, msg)
+
+		if err := msg.Ack(); err != nil {
+			log.Printf("[Code Review Worker %d] Failed to ack message: %v", id, err)
+		}
+	}
+}
+
+//go:embed prompts/code_review.tmpl
+var codeReviewTemplateText string
+
+var codeReviewTemplate = template.Must(template.New("code_review").Parse(codeReviewTemplateText))
+
+var codeReviewSchema = mustGenerateSchema(codeReviewOutput{})
+
+// codeReviewOutput is the JSON object the model reviews code with.
+type codeReviewOutput struct {
+	Summary         string   `json:"summary" description:"Overall feedback on the solution"`
+	Bugs            []string `json:"bugs" description:"Bugs that make the solution fail or could make it fail"`
+	TimeComplexity  string   `json:"timeComplexity" description:"Time complexity, with why it is too slow if it is"`
+	SpaceComplexity string   `json:"spaceComplexity" description:"Space complexity"`
+	Style           []string `json:"style" description:"Style issues"`
+}
+
+func (g *OpenAIGrader) Review(ctx context.Context, request CodeReviewRequestMessage) (models.CodeReview, error) {
+	var prompt strings.Builder
+	if err := codeReviewTemplate.Execute(&prompt, request); err != nil {
+		return models.CodeReview{}, fmt.Errorf("failed to render code review prompt: %w", err)
+	}
+
+	var output codeReviewOutput
+	err := g.complete(ctx, "code_review", "", codeReviewSchema, prompt.String(), func(content string) error {
+		if err := codeReviewSchema.Unmarshal(content, &output); err != nil {
+			return fmt.Errorf("%w: %v", errMalformedGrade, err)
+		}
+		if strings.TrimSpace(output.Summary) == "" {
+			return fmt.Errorf("%w: summary is empty", errMalformedGrade)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.CodeReview{}, err
+	}
+
+	return models.CodeReview(output), nil
+}
+
+// Review reports the failing test case, so reviews can be checked without a
+// model.
+func (FakeGrader) Review(ctx context.Context, request CodeReviewRequestMessage) (models.CodeReview, error) {
+	review := models.CodeReview{
+		Summary:         fmt.Sprintf("The %s solution was judged %s.", request.Language, request.Verdict),
+		Bugs:            []string{},
+		TimeComplexity:  "unknown",
+		SpaceComplexity: "unknown",
+		Style:           []string{},
+	}
+	if request.FailingCase != nil {
+		review.Bugs = append(review.Bugs, fmt.Sprintf("Test case %d failed with %s.", request.FailingCase.Index, request.FailingCase.Verdict))
+	}
+	return review, nil
+}
+
+// StartCodeReviewWorkers declares the code review queues and starts count
+// workers. The workers stop when ctx is done.
+func StartCodeReviewWorkers(ctx context.Context, deps *CodeReviewDeps, count int) error {
+	if deps.Reviewer == nil {
+		grader, err := NewLLMGrader(LoadLLMConfig())
+		if err != nil {
+			return err
+		}
+		deps.Reviewer = grader
+	}
+	deps.limiter = newUserRateLimiter(utils.GetEnvInt("CODE_REVIEW_LIMIT_PER_HOUR", 5), time.Hour)
+	go deps.limiter.pruneEvery(ctx, 10*time.Minute)
+
+	for _, queue := range []string{codeReviewRequestsQueue, CodeReviewsQueue} {
+		if err := deps.Broker.DeclareQueue(queue, broker.QueueOptions{}); err != nil {
+			return err
+		}
+	}
+
+	msgs, err := deps.Broker.Consume(ctx, codeReviewRequestsQueue, broker.ConsumeOptions{})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		go SpawnCodeReviewWorker(i, deps, msgs)
+	}
+
+	return nil
+}
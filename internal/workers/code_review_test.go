@@ -0,0 +1,122 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+)
+
+func TestUserRateLimiter(t *testing.T) {
+	limiter := newUserRateLimiter(2, 50*time.Millisecond)
+
+	for i, want := range []bool{true, true, false} {
+		if got := limiter.Allow("alice"); got != want {
+			t.Errorf("Allow %d = %v, want %v", i, got, want)
+		}
+	}
+	if !limiter.Allow("bob") {
+		t.Error("Allow(bob) = false, users are limited separately")
+	}
+
+	// Users without recent events are forgotten.
+	time.Sleep(100 * time.Millisecond)
+	if !limiter.Allow("carol") {
+		t.Error("Allow(carol) = false, want true")
+	}
+	limiter.prune()
+	if len(limiter.seen) != 1 {
+		t.Errorf("limiter tracks %d users, want only carol", len(limiter.seen))
+	}
+	if !limiter.Allow("alice") {
+		t.Error("Allow(alice) = false after the window, want true")
+	}
+}
+
+// failingReviewer can't review code.
+type failingReviewer struct{}
+
+func (failingReviewer) Review(ctx context.Context, request CodeReviewRequestMessage) (models.CodeReview, error) {
+	return models.CodeReview{}, errors.New("provider is down")
+}
+
+// startCodeReviewPipeline runs the compilation pipeline with code reviews
+// by reviewer, and returns the messages published to code_reviews.
+func startCodeReviewPipeline(t *testing.T, reviewer CodeReviewer, stdout string) (*compilationPipeline, <-chan broker.Delivery) {
+	t.Helper()
+	t.Setenv("CODE_REVIEW_ENABLED", "true")
+	t.Setenv("CODE_REVIEW_LIMIT_PER_HOUR", "1")
+
+	pipeline := startCompilationPipeline(t, func(ctx context.Context, language string, wrappedCode string) (Execution, error) {
+		return Execution{Stdout: stdout}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if err := StartCodeReviewWorkers(ctx, &CodeReviewDeps{Broker: pipeline.broker, Reviewer: reviewer}, 1); err != nil {
+		t.Fatalf("StartCodeReviewWorkers: %v", err)
+	}
+
+	reviews, err := pipeline.broker.Consume(ctx, CodeReviewsQueue, broker.ConsumeOptions{})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	return pipeline, reviews
+}
+
+func nextCodeReview(t *testing.T, reviews <-chan broker.Delivery) CodeReviewMessage {
+	t.Helper()
+
+	select {
+	case msg := <-reviews:
+		msg.Ack()
+		var review CodeReviewMessage
+		if err := json.Unmarshal(msg.Message().Body, &review); err != nil {
+			t.Fatalf("invalid code review %s: %v", msg.Message().Body, err)
+		}
+		return review
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a code review")
+		return CodeReviewMessage{}
+	}
+}
+
+func TestCodeReviewPipeline(t *testing.T) {
+	pipeline, reviews := startCodeReviewPipeline(t, FakeGrader{}, "3\n5\n")
+
+	pipeline.submit(t, "reviewed")
+	pipeline.events(t)
+
+	review := nextCodeReview(t, reviews)
+	if review.SubmissionId != "reviewed" || review.Event != EventCodeReview || review.Status != "SUCCEEDED" || review.Verdict != models.VerdictWrongAnswer {
+		t.Fatalf("code review = %+v, want a SUCCEEDED review of the wrong answer", review)
+	}
+	if review.Review == nil || len(review.Review.Bugs) != 1 || review.Review.Bugs[0] != "Test case 1 failed with WRONG_ANSWER." {
+		t.Errorf("review = %+v, want the failing case by index and verdict only", review.Review)
+	}
+
+	// The socket already had its review this hour.
+	pipeline.submit(t, "limited")
+	pipeline.events(t)
+
+	if review := nextCodeReview(t, reviews); review.SubmissionId != "limited" || review.Status != "RATE_LIMITED" || review.Review != nil {
+		t.Errorf("code review = %+v, want RATE_LIMITED", review)
+	}
+}
+
+func TestCodeReviewPipelineFailed(t *testing.T) {
+	pipeline, reviews := startCodeReviewPipeline(t, failingReviewer{}, "3\n4\n")
+
+	pipeline.submit(t, "failed")
+	pipeline.events(t)
+
+	review := nextCodeReview(t, reviews)
+	if review.SubmissionId != "failed" || review.Status != "FAILED" || review.Error == "" || review.Verdict != models.VerdictAccepted {
+		t.Errorf("code review = %+v, want a FAILED review of the accepted submission", review)
+	}
+}
@@ -0,0 +1,182 @@
+package workers
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/utils"
+)
+
+const compilationRequestsQueue = "compilation_requests"
+
+// Lane is a queue that compilation workers consume from. Lanes with a higher
+// weight get more messages per round when several lanes have work queued.
+type Lane struct {
+	Name   string
+	Queue  string
+	Weight int
+}
+
+type LaneConsumer struct {
+	Lane Lane
+	Msgs <-chan broker.Delivery
+}
+
+// LaneConfig describes how compilation requests are split into lanes and
+// which priority each request gets inside its lane.
+type LaneConfig struct {
+	Lanes          []Lane
+	MaxPriority    int
+	RunPriority    int
+	SubmitPriority int
+	RoomPriority   int
+}
+
+// LoadLaneConfig reads the lane configuration from the environment.
+//
+// COMPILATION_LANES is a comma separated list of name:queue:weight entries,
+// e.g. "run:compilation_requests_run:4,submit:compilation_requests_submit:1".
+// Lane names are matched against the submission type, optionally prefixed
+// with "room-" for submissions made from a room, with "default" as fallback.
+// When COMPILATION_LANES is empty the workers consume compilation_requests
+// directly, as they always have.
+func LoadLaneConfig() (LaneConfig, error) {
+	config := LaneConfig{
+		MaxPriority:    utils.GetEnvInt("COMPILATION_MAX_PRIORITY", 0),
+		RunPriority:    utils.GetEnvInt("COMPILATION_PRIORITY_RUN", 5),
+		SubmitPriority: utils.GetEnvInt("COMPILATION_PRIORITY_SUBMIT", 1),
+		RoomPriority:   utils.GetEnvInt("COMPILATION_PRIORITY_ROOM", 0),
+	}
+
+	spec := strings.TrimSpace(utils.GetEnv("COMPILATION_LANES", ""))
+	if spec == "" {
+		return config, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return config, fmt.Errorf("invalid lane %q, expected name:queue:weight", entry)
+		}
+
+		lane := Lane{Name: parts[0], Queue: parts[1], Weight: 1}
+		if len(parts) == 3 {
+			weight, err := strconv.Atoi(parts[2])
+			if err != nil || weight < 1 {
+				return config, fmt.Errorf("invalid weight for lane %q", parts[0])
+			}
+			lane.Weight = weight
+		}
+
+		if lane.Queue == compilationRequestsQueue {
+			return config, fmt.Errorf("lane %q cannot use the intake queue %s", lane.Name, compilationRequestsQueue)
+		}
+
+		config.Lanes = append(config.Lanes, lane)
+	}
+
+	return config, nil
+}
+
+func (c LaneConfig) Enabled() bool {
+	return len(c.Lanes) > 0
+}
+
+// QueueOptions returns the options lane queues are declared with.
+func (c LaneConfig) QueueOptions() broker.QueueOptions {
+	return broker.QueueOptions{MaxPriority: c.MaxPriority}
+}
+
+// Route picks the lane and priority for a submission of the given type.
+func (c LaneConfig) Route(runType string, roomId string) (Lane, uint8) {
+	priority := c.RunPriority
+	if runType == "submit" {
+		priority = c.SubmitPriority
+	}
+
+	candidates := []string{runType, "default"}
+	if roomId != "" {
+		priority += c.RoomPriority
+		candidates = append([]string{"room-" + runType}, candidates...)
+	}
+
+	if priority < 0 {
+		priority = 0
+	}
+	if priority > c.MaxPriority {
+		priority = c.MaxPriority
+	}
+
+	for _, name := range candidates {
+		for _, lane := range c.Lanes {
+			if lane.Name == name {
+				return lane, uint8(priority)
+			}
+		}
+	}
+
+	return c.Lanes[0], uint8(priority)
+}
+
+// MergeLanes fans the lane consumers into a single channel using weighted
+// round robin, so a burst on one lane cannot starve the others.
+func MergeLanes(consumers []LaneConsumer) <-chan broker.Delivery {
+	out := make(chan broker.Delivery)
+
+	go func() {
+		defer close(out)
+
+		closed := make([]bool, len(consumers))
+		open := len(consumers)
+
+		for open > 0 {
+			delivered := false
+
+			for i, consumer := range consumers {
+				for n := 0; n < consumer.Lane.Weight && !closed[i]; n++ {
+					select {
+					case msg, ok := <-consumer.Msgs:
+						if !ok {
+							closed[i] = true
+							open--
+							break
+						}
+						out <- msg
+						delivered = true
+						continue
+					default:
+					}
+					break
+				}
+			}
+
+			if delivered || open == 0 {
+				continue
+			}
+
+			// Every lane is empty, so block until any of them gets a message.
+			cases := make([]reflect.SelectCase, 0, open)
+			indexes := make([]int, 0, open)
+			for i, consumer := range consumers {
+				if closed[i] {
+					continue
+				}
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(consumer.Msgs)})
+				indexes = append(indexes, i)
+			}
+
+			chosen, value, ok := reflect.Select(cases)
+			if !ok {
+				closed[indexes[chosen]] = true
+				open--
+				continue
+			}
+			out <- value.Interface().(broker.Delivery)
+		}
+	}()
+
+	return out
+}
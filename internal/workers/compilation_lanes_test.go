@@ -0,0 +1,125 @@
+package workers
+
+import (
+	"strings"
+	"testing"
+
+	"octree.io-worker/internal/broker"
+)
+
+func TestLoadLaneConfig(t *testing.T) {
+	t.Setenv("COMPILATION_MAX_PRIORITY", "10")
+	t.Setenv("COMPILATION_LANES", " run:compilation_requests_run:4, submit:compilation_requests_submit ")
+
+	config, err := LoadLaneConfig()
+	if err != nil {
+		t.Fatalf("LoadLaneConfig: %v", err)
+	}
+	want := []Lane{
+		{Name: "run", Queue: "compilation_requests_run", Weight: 4},
+		{Name: "submit", Queue: "compilation_requests_submit", Weight: 1},
+	}
+	if len(config.Lanes) != len(want) || config.Lanes[0] != want[0] || config.Lanes[1] != want[1] {
+		t.Errorf("lanes = %+v, want %+v", config.Lanes, want)
+	}
+	if !config.Enabled() || config.MaxPriority != 10 || config.RunPriority != 5 || config.SubmitPriority != 1 {
+		t.Errorf("config = %+v, want lanes with the default priorities", config)
+	}
+
+	t.Setenv("COMPILATION_LANES", "")
+	if config, err := LoadLaneConfig(); err != nil || config.Enabled() {
+		t.Errorf("LoadLaneConfig without lanes = %+v, %v, want lanes disabled", config, err)
+	}
+
+	for _, test := range []struct {
+		lanes   string
+		wantErr string
+	}{
+		{"run", "expected name:queue:weight"},
+		{"run:queue:1:2", "expected name:queue:weight"},
+		{"run:queue:fast", "invalid weight"},
+		{"run:queue:0", "invalid weight"},
+		{"default:compilation_requests", "intake queue"},
+	} {
+		t.Run(test.lanes, func(t *testing.T) {
+			t.Setenv("COMPILATION_LANES", test.lanes)
+			if _, err := LoadLaneConfig(); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("LoadLaneConfig = %v, want an error with %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLaneConfigRoute(t *testing.T) {
+	config := LaneConfig{
+		Lanes: []Lane{
+			{Name: "submit", Queue: "submit"},
+			{Name: "room-run", Queue: "room-run"},
+			{Name: "default", Queue: "default"},
+		},
+		MaxPriority:    6,
+		RunPriority:    5,
+		SubmitPriority: 1,
+		RoomPriority:   3,
+	}
+
+	for _, test := range []struct {
+		runType, roomId string
+		wantLane        string
+		wantPriority    uint8
+	}{
+		{"submit", "", "submit", 1},
+		{"submit", "room-1", "submit", 4},
+		{"run", "", "default", 5},
+		{"run", "room-1", "room-run", 6},
+	} {
+		lane, priority := config.Route(test.runType, test.roomId)
+		if lane.Name != test.wantLane || priority != test.wantPriority {
+			t.Errorf("Route(%q, %q) = %s, %d, want %s, %d", test.runType, test.roomId, lane.Name, priority, test.wantLane, test.wantPriority)
+		}
+	}
+
+	// Without a matching or default lane, requests go to the first lane.
+	config.Lanes = config.Lanes[:1]
+	config.SubmitPriority = -2
+	if lane, priority := config.Route("run", ""); lane.Name != "submit" || priority != 5 {
+		t.Errorf("Route(run) = %s, %d, want submit, 5", lane.Name, priority)
+	}
+	if _, priority := config.Route("submit", ""); priority != 0 {
+		t.Errorf("priority = %d, want negative priorities raised to 0", priority)
+	}
+}
+
+// laneDelivery is a delivery that only knows its lane.
+type laneDelivery struct {
+	broker.Delivery
+	lane string
+}
+
+func TestMergeLanesWeights(t *testing.T) {
+	// Both lanes are full before merging starts, so every round takes
+	// Weight messages from each lane in order.
+	fill := func(lane string, n int) <-chan broker.Delivery {
+		msgs := make(chan broker.Delivery, n)
+		for range n {
+			msgs <- laneDelivery{lane: lane}
+		}
+		close(msgs)
+		return msgs
+	}
+
+	merged := MergeLanes([]LaneConsumer{
+		{Lane: Lane{Name: "run", Weight: 3}, Msgs: fill("run", 6)},
+		{Lane: Lane{Name: "submit", Weight: 1}, Msgs: fill("submit", 4)},
+	})
+
+	var order []string
+	for msg := range merged {
+		order = append(order, msg.(laneDelivery).lane)
+	}
+
+	want := "run run run submit run run run submit submit submit"
+	if got := strings.Join(order, " "); got != want {
+		t.Errorf("merged lanes = %s, want %s", got, want)
+	}
+}
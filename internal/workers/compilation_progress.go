@@ -0,0 +1,108 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/facade"
+	"octree.io-worker/internal/utils"
+)
+
+// Progress events published to compilation_responses while a submission is
+// processed. The final CompilationResponseMessage carries the FINISHED event.
+const (
+	EventQueued      = "QUEUED"
+	EventCompiling   = "COMPILING"
+	EventRunning     = "RUNNING"
+	EventCaseVerdict = "CASE_VERDICT"
+	EventFinished    = "FINISHED"
+)
+
+// CompilationProgressMessage is an intermediate event for a submission.
+//
+// QUEUED is sent when the request is placed in a lane, COMPILING once a worker
+// claimed the submission and builds the program, RUNNING when the program
+// starts with TotalTestCases set, and CASE_VERDICT once per test case with the
+// 1-based TestCase index and a PASSED or FAILED Verdict. The harness runs every
+// test case in one program whose output arrives when it exits, so the
+// CASE_VERDICT events are sent together after the run, not as cases finish.
+type CompilationProgressMessage struct {
+	Event          string `json:"event"`
+	SubmissionId   string `json:"submissionId"`
+	SocketId       string `json:"socketId"`
+	RoomId         string `json:"roomId,omitempty"`
+	Username       string `json:"username,omitempty"`
+	TestCase       int    `json:"testCase,omitempty"`
+	TotalTestCases int    `json:"totalTestCases,omitempty"`
+	Verdict        string `json:"verdict,omitempty"`
+	Timestamp      int64  `json:"timestamp"`
+}
+
+func progressEventsEnabled() bool {
+	return utils.GetEnvBool("COMPILATION_PROGRESS_EVENTS", true)
+}
+
+type progressReporter struct {
+	broker       broker.Broker
+	enabled      bool
+	submissionId string
+	socketId     string
+	roomId       string
+	username     string
+}
+
+func newProgressReporter(b broker.Broker, submissionId string, socketId string, roomId string, username string) *progressReporter {
+	return &progressReporter{
+		broker:       b,
+		enabled:      progressEventsEnabled() && socketId != "",
+		submissionId: submissionId,
+		socketId:     socketId,
+		roomId:       roomId,
+		username:     username,
+	}
+}
+
+func (p *progressReporter) send(message CompilationProgressMessage) {
+	if !p.enabled {
+		return
+	}
+
+	message.SubmissionId = p.submissionId
+	message.SocketId = p.socketId
+	message.RoomId = p.roomId
+	message.Username = p.username
+	message.Timestamp = time.Now().UnixMilli()
+
+	if err := publishResponse(p.broker, CompilationResponsesQueue, "", message); err != nil {
+		log.Printf("Failed to send %s progress event for submission %s: %v", message.Event, p.submissionId, err)
+	}
+}
+
+func (p *progressReporter) Queued() {
+	p.send(CompilationProgressMessage{Event: EventQueued})
+}
+
+func (p *progressReporter) Compiling() {
+	p.send(CompilationProgressMessage{Event: EventCompiling})
+}
+
+func (p *progressReporter) Running(totalTestCases int) {
+	p.send(CompilationProgressMessage{Event: EventRunning, TotalTestCases: totalTestCases})
+}
+
+func (p *progressReporter) CaseVerdicts(verdicts []facade.TestCaseVerdict) {
+	for _, verdict := range verdicts {
+		result := "FAILED"
+		if verdict.Passed {
+			result = "PASSED"
+		}
+
+		p.send(CompilationProgressMessage{
+			Event:          EventCaseVerdict,
+			TestCase:       verdict.Index + 1,
+			TotalTestCases: len(verdicts),
+			Verdict:        result,
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"octree.io-worker/internal/broker"
+)
+
+func routeCompilationRequest(deps *CompilationDeps, config LaneConfig, msg broker.Delivery) error {
+	body := msg.Message()
+
+	var message CompilationRequestMessage
+	if err := json.Unmarshal(body.Body, &message); err != nil {
+		return fmt.Errorf("failed to parse message to JSON: %w", err)
+	}
+
+	runType, roomId := message.Type, message.RoomId
+	if runType == "" && message.Inline() {
+		runType = "run"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if runType == "" {
+		submission, err := deps.Submissions.Get(ctx, message.SubmissionId)
+		if err != nil {
+			return fmt.Errorf("failed to look up submission %s: %w", message.SubmissionId, err)
+		}
+		runType, roomId = submission.RunType, submission.RoomId
+	}
+
+	lane, priority := config.Route(runType, roomId)
+
+	body.Priority = priority
+	err := deps.Broker.Publish(ctx, lane.Queue, body)
+	if err != nil {
+		return fmt.Errorf("failed to publish to lane %s: %w", lane.Name, err)
+	}
+
+	// Workers identify inline requests by their correlation id, and by a
+	// random id the router can't know when they have none.
+	submissionId := message.SubmissionId
+	if submissionId == "" && message.Inline() {
+		submissionId = body.CorrelationId
+	}
+	if submissionId != "" {
+		newProgressReporter(deps.Broker, submissionId, message.SocketId, roomId, "").Queued()
+	}
+
+	log.Printf("[Compilation Router] Routed submission %s (%s) to lane %s with priority %d", submissionId, runType, lane.Name, priority)
+	return nil
+}
+
+// SpawnCompilationRouter moves requests from the shared compilation_requests
+// queue into the configured lanes, so producers don't need to know about
+// lanes or priorities.
+func SpawnCompilationRouter(deps *CompilationDeps, config LaneConfig, msgs <-chan broker.Delivery) {
+	for msg := range msgs {
+		err := routeCompilationRequest(deps, config, msg)
+		if err != nil {
+			log.Printf("[Compilation Router] Failed to route message: %v", err)
+
+			// Give each message one more attempt before dropping it, so a
+			// malformed message can't loop through the router forever.
+			if err := msg.Nack(!msg.Redelivered()); err != nil {
+				log.Printf("[Compilation Router] Failed to nack message: %v", err)
+			}
+			continue
+		}
+
+		if err := msg.Ack(); err != nil {
+			log.Printf("[Compilation Router] Failed to ack message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"octree.io-worker/internal/broker"
+)
+
+// submitInline publishes an inline request adding 1 and 2 with the given AMQP
+// correlation id and reply queue.
+func (p *compilationPipeline) submitInline(t *testing.T, correlationId string, replyTo string) {
+	t.Helper()
+
+	body, _ := json.Marshal(CompilationRequestMessage{
+		SocketId:   "socket-1",
+		Language:   "python",
+		Code:       "def add(a, b): return a + b",
+		Args:       map[string]string{"a": "int", "b": "int"},
+		ReturnType: "int",
+		TestCases:  []InlineTestCase{{Input: map[string]interface{}{"a": 1, "b": 2}, Output: 3}},
+	})
+	err := p.broker.Publish(context.Background(), compilationRequestsQueue, broker.Message{
+		Body:          body,
+		ContentType:   "application/json",
+		CorrelationId: correlationId,
+		ReplyTo:       replyTo,
+	})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+func addOneAndTwo(ctx context.Context, language string, wrappedCode string) (Execution, error) {
+	return Execution{Stdout: "3\n"}, nil
+}
+
+func TestCompilationRouterQueuesInlineRequestsByCorrelationId(t *testing.T) {
+	pipeline := startCompilationPipeline(t, addOneAndTwo)
+
+	pipeline.submitInline(t, "correlation-1", "")
+
+	events := pipeline.events(t)
+	if events[0].Event != EventQueued {
+		t.Fatalf("events = %v, want QUEUED first", eventNames(events))
+	}
+	for _, event := range events {
+		if event.SubmissionId != "correlation-1" {
+			t.Errorf("%s event of submission %q, want correlation-1", event.Event, event.SubmissionId)
+		}
+	}
+}
+
+func TestCompilationRouterSkipsQueuedWithoutId(t *testing.T) {
+	pipeline := startCompilationPipeline(t, addOneAndTwo)
+
+	// The worker makes up an id for the request, so the router has none to
+	// send QUEUED with.
+	pipeline.submitInline(t, "", "")
+
+	events := pipeline.events(t)
+	if events[0].Event == EventQueued {
+		t.Errorf("events = %v, want no QUEUED event", eventNames(events))
+	}
+	if id := events[len(events)-1].SubmissionId; id == "" {
+		t.Error("FINISHED without a submission id")
+	}
+}
@@ -1,19 +1,26 @@
 package workers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	ampq "github.com/rabbitmq/amqp091-go"
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 
-	"octree.io-worker/internal/clients"
+	"octree.io-worker/internal/broker"
 	"octree.io-worker/internal/facade"
+	"octree.io-worker/internal/helpers"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
 	testharness "octree.io-worker/internal/test_harness"
 	"octree.io-worker/internal/utils"
 )
@@ -23,13 +30,13 @@ type OutputItem struct {
 }
 
 type BuildResult struct {
-	Code               int      `json:"code"`
-	TimedOut           bool     `json:"timedOut"`
-	Stdout             []string `json:"stdout"`
-	Stderr             []string `json:"stderr"`
-	Downloads          []string `json:"downloads"`
-	ExecutableFilename string   `json:"executableFilename"`
-	CompilationOptions []string `json:"compilationOptions"`
+	Code               int          `json:"code"`
+	TimedOut           bool         `json:"timedOut"`
+	Stdout             []OutputItem `json:"stdout"`
+	Stderr             []OutputItem `json:"stderr"`
+	Downloads          []string     `json:"downloads"`
+	ExecutableFilename string       `json:"executableFilename"`
+	CompilationOptions []string     `json:"compilationOptions"`
 }
 
 type CompilerExplorerResponse struct {
@@ -45,9 +52,36 @@ type CompilerExplorerResponse struct {
 	BuildResult                BuildResult  `json:"buildResult"`
 }
 
+// CompilationRequestMessage either references a row in the submissions table
+// by SubmissionId, or carries the code inline. Inline requests run against the
+// test cases of ProblemId, or against TestCases with Args and ReturnType, and
+// are not stored. Requests with an AMQP ReplyTo get their response there
+// instead of on compilation_responses, with the request's CorrelationId.
 type CompilationRequestMessage struct {
 	SubmissionId string `json:"submissionId"`
 	SocketId     string `json:"socketId"`
+	Type         string `json:"type,omitempty"`
+	RoomId       string `json:"roomId,omitempty"`
+
+	Language       string            `json:"language,omitempty"`
+	Code           string            `json:"code,omitempty"`
+	ProblemId      int               `json:"problemId,omitempty"`
+	Args           map[string]string `json:"args,omitempty"`
+	ReturnType     string            `json:"returnType,omitempty"`
+	TestCases      []InlineTestCase  `json:"testCases,omitempty"`
+	AnswerAnyOrder bool              `json:"answerAnyOrder,omitempty"`
+	DeepSort       bool              `json:"deepSort,omitempty"`
+}
+
+type InlineTestCase struct {
+	Input  map[string]interface{} `json:"input"`
+	Output interface{}            `json:"output"`
+}
+
+// Inline reports whether the request carries its code instead of referencing
+// a stored submission.
+func (m CompilationRequestMessage) Inline() bool {
+	return m.Code != ""
 }
 
 type CompilationResponseMessage struct {
@@ -58,311 +92,605 @@ type CompilationResponseMessage struct {
 	Language     string `json:"language"`
 	Type         string `json:"type"`
 	Status       string `json:"status"`
+	Verdict      string `json:"verdict,omitempty"`
 	Stdout       string `json:"stdout"`
 	Stderr       string `json:"stderr"`
 	ExecTime     string `json:"execTime"`
+	Event        string `json:"event"`
 }
 
-func queryProblemByID(client *mongo.Client, id int) (bson.M, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// compilationJob is a submission being processed, either claimed from the
+// submissions table or given inline in the request.
+type compilationJob struct {
+	SubmissionId   string
+	SocketId       string
+	RoomId         string
+	Username       string
+	ProblemId      int
+	ProblemVersion int
+	Language       string
+	Code           string
+	RunType        string
+	ReplyTo        string
+	CorrelationId  string
+
+	// lease is nil for inline requests, which are not stored.
+	lease *submissionLease
+}
 
-	collection := client.Database("octree").Collection("problems")
+func (j *compilationJob) response(status string) CompilationResponseMessage {
+	return CompilationResponseMessage{
+		SubmissionId: j.SubmissionId,
+		SocketId:     j.SocketId,
+		Username:     j.Username,
+		RoomId:       j.RoomId,
+		Language:     j.Language,
+		Type:         j.RunType,
+		Status:       status,
+	}
+}
 
-	filter := bson.M{"id": id}
+// testData is what a program is run and judged against.
+type testData struct {
+	ProblemVersion int
+	Title          string
+	Description    string
+	Args           map[string]string
+	TestCases      []map[string]interface{}
+	Outputs        []map[string]interface{}
+	ReturnType     string
+	AnswerAnyOrder bool
+	DeepSort       bool
+
+	// harnesses caches a harness per language with harnessCodePlaceholder in
+	// place of the submitted code.
+	harnesses sync.Map
+}
 
-	var result bson.M
-	err := collection.FindOne(ctx, filter).Decode(&result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find problem by ID: %v", err)
+func problemTestData(problem *models.Problem, runType string) *testData {
+	data := &testData{
+		ProblemVersion: problem.Version,
+		Title:          problem.Title,
+		Description:    problem.Description,
+		Args:           problem.Args,
+		TestCases:      []map[string]interface{}{},
+		Outputs:        []map[string]interface{}{},
+		ReturnType:     problem.ReturnType,
+		AnswerAnyOrder: problem.AnswerAnyOrder,
+		DeepSort:       problem.DeepSort,
 	}
 
-	return result, nil
-}
+	log.Printf("answerAnyOrder: %v\ndeepSort: %v\n", data.AnswerAnyOrder, data.DeepSort)
 
-func sendCompilationResponseMessage(response CompilationResponseMessage) error {
-	conn, err := clients.GetRabbitMQConnection()
-	if err != nil {
-		return fmt.Errorf("failed to get RabbitMQ connection: %w", err)
+	for _, testCase := range problem.TestCases(runType) {
+		outputMap := map[string]interface{}{
+			"output": utils.ConvertBsonToNative(testCase.Output),
+		}
+
+		data.TestCases = append(data.TestCases, map[string]interface{}(testCase.Input))
+		data.Outputs = append(data.Outputs, outputMap)
 	}
 
-	ch, err := conn.Channel()
-	if err != nil {
-		return fmt.Errorf("failed to open a RabbitMQ channel: %w", err)
-	}
-	defer ch.Close()
-
-	queueName := "compilation_responses"
-	_, err = ch.QueueDeclare(
-		queueName, // queue name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare queue: %w", err)
+	return data
+}
+
+// inlineTestData validates the test cases of an inline request like those of
+// a stored problem.
+func inlineTestData(message CompilationRequestMessage, runType string) (*testData, error) {
+	problem := &models.Problem{
+		Args:           message.Args,
+		ReturnType:     message.ReturnType,
+		AnswerAnyOrder: message.AnswerAnyOrder,
+		DeepSort:       message.DeepSort,
 	}
 
-	messageBody, err := json.Marshal(response)
-	if err != nil {
-		return fmt.Errorf("failed to marshal response message: %w", err)
+	for _, testCase := range message.TestCases {
+		input, _ := utils.ConvertJSONNumbers(testCase.Input).(map[string]interface{})
+		problem.SampleTestCases = append(problem.SampleTestCases, models.TestCase{
+			Input:  bson.M(input),
+			Output: utils.ConvertJSONNumbers(testCase.Output),
+		})
 	}
+	problem.JudgeTestCases = problem.SampleTestCases
 
-	err = ch.Publish(
-		"",        // exchange
-		queueName, // routing key (queue name)
-		false,     // mandatory
-		false,     // immediate
-		ampq.Publishing{
-			ContentType: "application/json",
-			Body:        messageBody,
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to publish compilation response message: %w", err)
+	if err := problem.Validate(); err != nil {
+		return nil, err
 	}
 
-	log.Printf("Compilation response message sent to queue: %s\n", queueName)
-	return nil
+	return problemTestData(problem, runType), nil
 }
 
-func processCompilationRequest(msg ampq.Delivery) {
-	var message CompilationRequestMessage
-
-	err := json.Unmarshal(msg.Body, &message)
-	if err != nil {
-		log.Printf("Failed to parse message to JSON: %v\n", err)
-		return
+// problemError is the reason a submission failed because of its problem.
+func problemError(err error) string {
+	if errors.Is(err, models.ErrInvalidProblem) {
+		return err.Error()
 	}
+	return "problem not found"
+}
 
-	submissionId := message.SubmissionId
-	socketId := message.SocketId
+// harnessCodePlaceholder stands in for the submitted code in cached harnesses.
+// The harnesses insert the code verbatim, once, so it can be substituted.
+const harnessCodePlaceholder = "\x00octree-submitted-code\x00"
 
-	if submissionId == "" {
-		log.Println("SubmissionId is missing or empty")
-		return
+// wrapCode wraps code in the test harness for its language.
+func wrapCode(language string, code string, data *testData) (string, error) {
+	harness, ok := data.harnesses.Load(language)
+	if !ok {
+		generated, err := generateHarness(language, harnessCodePlaceholder, data)
+		if err != nil {
+			return "", err
+		}
+		harness, _ = data.harnesses.LoadOrStore(language, generated)
 	}
 
-	if socketId == "" {
-		log.Println("SocketId is missing or empty")
-		return
-	}
+	return strings.Replace(harness.(string), harnessCodePlaceholder, code, 1), nil
+}
 
-	ctx := context.Background()
-	pgPool, err := clients.GetPostgresPool()
-	if err != nil {
-		log.Fatalf("Unable to connect to PostgreSQL: %v\n", err)
-	}
+func generateHarness(language string, code string, data *testData) (string, error) {
+	switch language {
+	case "python":
+		return testharness.PythonHarness(code, data.Args, data.TestCases, data.ReturnType), nil
 
-	var (
-		problemId int
-		language  string
-		code      string
-		runType   string
-		roomId    string
-		username  string
-	)
+	case "cpp":
+		return testharness.CppHarness(code, data.Args, data.TestCases, data.ReturnType), nil
 
-	err = pgPool.QueryRow(
-		ctx,
-		"SELECT problem_id, language, code, type, room_id, username FROM submissions WHERE submission_id=$1", submissionId,
-	).Scan(&problemId, &language, &code, &runType, &roomId, &username)
+	case "csharp":
+		return testharness.CsharpHarness(code, data.Args, data.TestCases, data.ReturnType), nil
 
-	if err != nil {
-		log.Printf("Query failed: %v\n", err)
-		return
-	}
-	log.Printf("Problem ID: %d\nLanguage: %s\nCode: %s\nRun type: %s\nRoom ID: %s\n", problemId, language, code, runType, roomId)
+	case "java":
+		return testharness.JavaHarness(code, data.Args, data.TestCases, data.ReturnType), nil
 
-	client, err := clients.GetMongoClient()
-	if err != nil {
-		log.Fatalf("MongoDB connection error: %v", err)
-	}
+	case "ruby":
+		return testharness.RubyHarness(code, data.Args, data.TestCases, data.ReturnType), nil
 
-	problem, err := queryProblemByID(client, problemId)
-	if err != nil {
-		log.Printf("Error finding problem: %v", err)
-		return
-	}
+	case "javascript":
+		return testharness.JavaScriptHarness(code, data.Args, data.TestCases, data.ReturnType), nil
 
-	args := utils.ConvertBsonMToStringMap(problem["args"].(bson.M))
-	testCases := []map[string]interface{}{}
-	outputs := []map[string]interface{}{}
-	returnType := problem["returnType"].(string)
+	case "typescript":
+		return testharness.TypeScriptHarness(code, data.Args, data.TestCases, data.ReturnType), nil
 
-	answerAnyOrder, ok := problem["answerAnyOrder"].(bool)
-	if !ok {
-		answerAnyOrder = false
+	default:
+		return "", fmt.Errorf("unsupported language: %s", language)
 	}
+}
 
-	deepSort, ok := problem["deepSort"].(bool)
-	if !ok {
-		deepSort = false
-	}
+// ExecuteCode runs a program wrapped in a test harness on Compiler Explorer, or
+// locally on wasmtime for JavaScript and TypeScript.
+func ExecuteCode(ctx context.Context, language string, wrappedCode string) (Execution, error) {
+	var execution Execution
+	start := time.Now()
 
-	log.Printf("answerAnyOrder: %v\ndeepSort: %v\n", answerAnyOrder, deepSort)
+	switch language {
+	case "javascript", "typescript":
+		execute := facade.ExecuteJavaScript
+		if language == "typescript" {
+			execute = facade.ExecuteTypeScript
+		}
 
-	testCasesKey := "sampleTestCases"
-	if runType == "submit" {
-		testCasesKey = "judgeTestCases"
-	}
+		stdout, stderr, memory, err := execute(ctx, language, wrappedCode)
+
+		execution.Stdout = stdout
+		execution.Stderr = stderr
+		execution.Memory = memory
+		execution.Compiler = "wasmtime"
+		execution.ExecTime = int(time.Since(start).Milliseconds())
+		execution.WallTime = execution.ExecTime
+
+		switch {
+		case errors.Is(err, helpers.ErrCompilationFailed):
+			execution.CompilationFailed = true
+		case errors.Is(err, helpers.ErrTimeLimitExceeded):
+			execution.TimedOut = true
+		case errors.Is(err, helpers.ErrRuntimeError):
+			execution.ExitCode = 1
+		case err != nil:
+			return execution, fmt.Errorf("failed to execute %s: %w", language, err)
+		}
 
-	for _, entry := range problem[testCasesKey].(bson.A) {
-		entryBytes, err := bson.Marshal(entry)
+	default:
+		output, err := facade.CompilerExplorer(ctx, language, wrappedCode)
 		if err != nil {
-			log.Printf("Failed to marshal bson.D: %v", err)
-			return
+			return execution, fmt.Errorf("failed to execute on Compiler Explorer: %w", err)
+		}
+
+		var jsonOutput CompilerExplorerResponse
+		if err := json.Unmarshal(([]byte)(output), &jsonOutput); err != nil {
+			return execution, fmt.Errorf("failed to parse Compiler Explorer response: %w", err)
+		}
+
+		execution.ExecTime = jsonOutput.ExecTime
+		execution.WallTime = int(time.Since(start).Milliseconds())
+		execution.ExitCode = jsonOutput.Code
+		execution.TimedOut = jsonOutput.TimedOut || jsonOutput.BuildResult.TimedOut
+		execution.Compiler = facade.COMPILERS[language]
+
+		for _, out := range jsonOutput.Stdout {
+			execution.Stdout += out.Text + "\n"
+		}
+
+		for _, err := range jsonOutput.Stderr {
+			execution.Stderr += err.Text + "\n"
+		}
+
+		// Compiled languages report build errors separately and don't run.
+		if jsonOutput.BuildResult.Code != 0 {
+			execution.CompilationFailed = true
+			for _, err := range jsonOutput.BuildResult.Stderr {
+				execution.Stderr += err.Text + "\n"
+			}
 		}
 
-		var entryMap bson.M
-		err = bson.Unmarshal(entryBytes, &entryMap)
+		execution.CompilerVersion, err = facade.CompilerVersion(ctx, language)
 		if err != nil {
-			log.Printf("Failed to unmarshal to bson.M: %v", err)
-			return
+			log.Printf("Failed to look up the %s compiler version: %v", language, err)
 		}
 
-		input := map[string]interface{}(entryMap["input"].(bson.M))
-		output := entryMap["output"]
+		log.Printf("Request took %s to execute and %s to run", strconv.Itoa(execution.ExecTime), strconv.Itoa(execution.WallTime))
+	}
+
+	return execution, nil
+}
 
-		outputMap := map[string]interface{}{
-			"output": utils.ConvertBsonToNative(output),
+// submissionVerdict judges an execution against the verdicts of its test
+// cases.
+func submissionVerdict(execution Execution, verdicts []facade.TestCaseVerdict) string {
+	switch {
+	case execution.CompilationFailed:
+		return models.VerdictCompilationError
+	case execution.TimedOut:
+		return models.VerdictTimeLimitExceeded
+	case execution.ExitCode != 0:
+		return models.VerdictRuntimeError
+	case facade.AllTestCasesPassed(verdicts, execution.Stdout):
+		return models.VerdictAccepted
+	default:
+		return models.VerdictWrongAnswer
+	}
+}
+
+// submissionStatus is FAILED for submits that weren't accepted, and SUCCEEDED
+// otherwise.
+func submissionStatus(runType string, result *models.SubmissionResult) string {
+	if runType == "submit" && result.Verdict != models.VerdictAccepted {
+		return "FAILED"
+	}
+	return "SUCCEEDED"
+}
+
+func newSubmissionResult(execution Execution, verdicts []facade.TestCaseVerdict) *models.SubmissionResult {
+	result := &models.SubmissionResult{
+		Verdict:         submissionVerdict(execution, verdicts),
+		Stdout:          execution.Stdout,
+		Stderr:          execution.Stderr,
+		ExecTime:        execution.ExecTime,
+		WallTime:        execution.WallTime,
+		Memory:          execution.Memory,
+		Compiler:        execution.Compiler,
+		CompilerVersion: execution.CompilerVersion,
+	}
+
+	for _, verdict := range verdicts {
+		testResult := models.TestResult{
+			Index:      verdict.Index,
+			Passed:     verdict.Passed,
+			Verdict:    models.VerdictAccepted,
+			Expected:   verdict.Expected,
+			Actual:     verdict.Actual,
+			OutputHash: fmt.Sprintf("%x", sha256.Sum256([]byte(verdict.Actual))),
+		}
+
+		// Cases without output didn't run because the program failed earlier.
+		if !verdict.Passed {
+			testResult.Verdict = models.VerdictWrongAnswer
+			if verdict.Actual == "" && result.Verdict != models.VerdictWrongAnswer {
+				testResult.Verdict = result.Verdict
+			}
 		}
 
-		testCases = append(testCases, input)
-		outputs = append(outputs, outputMap)
+		result.TestResults = append(result.TestResults, testResult)
 	}
 
-	var wrappedCode string
+	result.TruncateOutput(utils.GetEnvInt("SUBMISSION_OUTPUT_LIMIT", 64*1024))
+	return result
+}
 
-	switch language {
-	case "python":
-		wrappedCode = testharness.PythonHarness(code, args, testCases, returnType)
+const CompilationResponsesQueue = "compilation_responses"
 
-	case "cpp":
-		wrappedCode = testharness.CppHarness(code, args, testCases, returnType)
+func sendCompilationResponseMessage(b broker.Broker, job *compilationJob, response CompilationResponseMessage) error {
+	response.Event = EventFinished
 
-	case "csharp":
-		wrappedCode = testharness.CsharpHarness(code, args, testCases, returnType)
+	if job.ReplyTo != "" {
+		return publishResponse(b, job.ReplyTo, job.CorrelationId, response)
+	}
+	return publishResponse(b, CompilationResponsesQueue, "", response)
+}
 
-	case "java":
-		wrappedCode = testharness.JavaHarness(code, args, testCases, returnType)
+func publishResponse(b broker.Broker, queueName string, correlationId string, response interface{}) error {
+	messageBody, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response message: %w", err)
+	}
 
-	case "ruby":
-		wrappedCode = testharness.RubyHarness(code, args, testCases, returnType)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	case "javascript":
-		wrappedCode = testharness.JavaScriptHarness(code, args, testCases, returnType)
+	err = b.Publish(ctx, queueName, broker.Message{
+		ContentType:   "application/json",
+		CorrelationId: correlationId,
+		Body:          messageBody,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish response message: %w", err)
+	}
 
-	case "typescript":
-		wrappedCode = testharness.TypeScriptHarness(code, args, testCases, returnType)
+	log.Printf("Response message sent to queue: %s\n", queueName)
+	return nil
+}
 
-	default:
-		fmt.Println("Unsupported language")
-		return
+// PublishCompilationRequest queues a request for the compilation workers.
+func PublishCompilationRequest(ctx context.Context, b broker.Broker, message CompilationRequestMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal compilation request: %w", err)
 	}
 
-	var stdout, stderr string
-	var execTime int
+	return b.Publish(ctx, compilationRequestsQueue, broker.Message{Body: body, ContentType: "application/json"})
+}
 
-	switch language {
-	case "typescript":
-		start := time.Now()
-		stdout, stderr, err = facade.ExecuteTypeScript(language, wrappedCode)
-		elapsed := time.Since(start).Milliseconds()
+func parseCompilationRequest(body []byte) (CompilationRequestMessage, error) {
+	var message CompilationRequestMessage
 
-		execTime = int(elapsed)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	err := decoder.Decode(&message)
 
-		if err != nil {
-			fmt.Println("Error while executing TypeScript")
+	return message, err
+}
+
+// processCompilationRequest runs a compilation request and publishes its
+// response. It returns how long to wait before the message is delivered
+// again when another worker holds the submission's lease, and 0 when the
+// message is done with.
+func processCompilationRequest(workerId int, deps *CompilationDeps, msg broker.Delivery) time.Duration {
+	delivery := msg.Message()
+
+	message, err := parseCompilationRequest(delivery.Body)
+	if err != nil {
+		log.Printf("Failed to parse message to JSON: %v\n", err)
+		return 0
+	}
+
+	job := &compilationJob{
+		SubmissionId:  message.SubmissionId,
+		SocketId:      message.SocketId,
+		ReplyTo:       delivery.ReplyTo,
+		CorrelationId: delivery.CorrelationId,
+	}
+
+	if message.Inline() && job.SubmissionId == "" {
+		job.SubmissionId = delivery.CorrelationId
+		if job.SubmissionId == "" {
+			job.SubmissionId = uuid.New().String()
 		}
+	}
 
-	case "javascript":
-		start := time.Now()
-		stdout, stderr, err = facade.ExecuteJavaScript(language, wrappedCode)
-		elapsed := time.Since(start).Milliseconds()
-		execTime = int(elapsed)
+	if job.SubmissionId == "" {
+		log.Println("SubmissionId is missing or empty")
+		return 0
+	}
+
+	// Stored submissions may be requeued without a client, e.g. by rejudges;
+	// their result is in the submissions table.
+	if message.Inline() && job.SocketId == "" && job.ReplyTo == "" {
+		log.Println("SocketId is missing or empty")
+		return 0
+	}
+
+	// runCtx is cancelled by cancel messages from the control exchange. The
+	// database is updated with ctx, so a cancelled submission can still be
+	// marked as CANCELLED.
+	ctx := context.Background()
+	var runCtx context.Context
+	var release func()
+
+	var data *testData
+
+	if message.Inline() {
+		runCtx, release = cancellations.Register(job.SubmissionId, job.SocketId)
+		defer release()
+
+		job.RoomId = message.RoomId
+		job.ProblemId = message.ProblemId
+		job.Language = message.Language
+		job.Code = message.Code
+		job.RunType = message.Type
+		if job.RunType == "" {
+			job.RunType = "run"
+		}
+
+		if len(message.TestCases) > 0 {
+			data, err = inlineTestData(message, job.RunType)
+			if err != nil {
+				log.Printf("Invalid inline test cases: %v", err)
+				failJob(ctx, deps.Broker, job, err.Error())
+				return 0
+			}
+		}
+	} else {
+		submission, lease, err := claimSubmission(ctx, deps.Submissions, job.SubmissionId, leaseOwner(workerId))
+		if errors.Is(err, repository.ErrSubmissionLeaseHeld) {
+			// The owner is usually alive and judging a duplicate of this
+			// message; if it died, the lease expires before the retry.
+			delay := leaseRetryDelay(ctx, deps.Submissions, job.SubmissionId)
+			log.Printf("Submission %s is leased by another worker, retrying in %v\n", job.SubmissionId, delay)
+			return delay
+		}
+		if errors.Is(err, repository.ErrSubmissionAlreadyProcessed) {
+			log.Printf("Skipping submission %s: %v\n", job.SubmissionId, err)
+			return 0
+		}
 		if err != nil {
-			fmt.Println("Error while executing JavaScript")
+			log.Printf("Query failed: %v\n", err)
+			return 0
 		}
 
-	default:
-		start := time.Now()
+		job.ProblemId = submission.ProblemId
+		job.ProblemVersion = submission.ProblemVersion
+		job.Language = submission.Language
+		job.Code = submission.Code
+		job.RunType = submission.RunType
+		job.RoomId = submission.RoomId
+		job.Username = submission.Username
+		job.lease = lease
+
+		// Register only once the submission is claimed, so a duplicate
+		// delivery can't take over the registration of the worker running
+		// it. A cancel that arrived before is pending and applies now.
+		runCtx, release = cancellations.Register(job.SubmissionId, job.SocketId)
+		defer release()
+
+		if runCtx.Err() != nil {
+			cancelJob(ctx, deps.Broker, job)
+			return 0
+		}
+	}
+
+	log.Printf("Problem ID: %d\nLanguage: %s\nCode: %s\nRun type: %s\nRoom ID: %s\n", job.ProblemId, job.Language, job.Code, job.RunType, job.RoomId)
+
+	progress := newProgressReporter(deps.Broker, job.SubmissionId, job.SocketId, job.RoomId, job.Username)
 
-		output, err := facade.CompilerExplorer(language, wrappedCode)
+	if data == nil {
+		data, err = deps.problems.TestData(ctx, job.ProblemId, job.ProblemVersion, job.RunType)
 		if err != nil {
-			log.Printf("Error while executing compile: %v", err)
+			log.Printf("Error finding problem: %v", err)
+			failJob(ctx, deps.Broker, job, problemError(err))
+			return 0
 		}
+	}
 
-		var jsonOutput CompilerExplorerResponse
-		json.Unmarshal(([]byte)(output), &jsonOutput)
+	progress.Compiling()
 
-		elapsed := time.Since(start).Milliseconds()
+	wrappedCode, err := wrapCode(job.Language, job.Code, data)
+	if err != nil {
+		fmt.Println("Unsupported language")
+		failJob(ctx, deps.Broker, job, "unsupported language")
+		return 0
+	}
 
-		execTime = int(jsonOutput.ExecTime)
+	if runCtx.Err() != nil {
+		cancelJob(ctx, deps.Broker, job)
+		return 0
+	}
 
-		for _, out := range jsonOutput.Stdout {
-			stdout += out.Text + "\n"
-		}
+	progress.Running(len(data.TestCases))
 
-		for _, err := range jsonOutput.Stderr {
-			stderr += err.Text + "\n"
-		}
+	execution, err := deps.Execute(runCtx, job.Language, wrappedCode)
 
-		log.Printf("Request took %s to execute and %s to run", strconv.Itoa(execTime), strconv.Itoa(int(elapsed)))
+	if runCtx.Err() != nil {
+		cancelJob(ctx, deps.Broker, job)
+		return 0
 	}
 
-	fmt.Printf("Exec time: %s\n", strconv.Itoa(execTime))
+	if err != nil {
+		log.Printf("Failed to execute submission %s: %v\n", job.SubmissionId, err)
+		failJob(ctx, deps.Broker, job, "execution failed")
+		return 0
+	}
+
+	fmt.Printf("Exec time: %s\n", strconv.Itoa(execution.ExecTime))
+
+	verdicts := facade.JudgeTestCaseResults(data.Outputs, execution.Stdout, data.AnswerAnyOrder, data.DeepSort, data.ReturnType)
+	progress.CaseVerdicts(verdicts)
 
-	outputString := fmt.Sprintf(`{"stdout": "%s", "stderr": "%s", "execTime": %s}`, stdout, stderr, strconv.Itoa(execTime))
+	result := newSubmissionResult(execution, verdicts)
+	result.ProblemVersion = data.ProblemVersion
 
-	status := "SUCCEEDED"
-	if runType == "submit" {
-		result := facade.JudgeTestCases(outputs, stdout, answerAnyOrder, deepSort, returnType)
-		fmt.Printf("Verdict: %v\n", result)
-		if !result {
-			status = "FAILED"
+	status := submissionStatus(job.RunType, result)
+	if job.RunType == "submit" {
+		fmt.Printf("Verdict: %v\n", result.Verdict)
+	}
+
+	if job.lease != nil {
+		owned, err := job.lease.Complete(ctx, result, status)
+		if err != nil {
+			log.Printf("Failed to update submission: %v\n", err)
+		} else if !owned {
+			log.Printf("Lease on submission %s was taken over, discarding result\n", job.SubmissionId)
+			return 0
 		}
 	}
 
-	updateQuery := `
-    UPDATE submissions
-		SET output = $1, status = $2
-		WHERE submission_id = $3;
-  `
+	responseMessage := job.response(status)
+	responseMessage.Verdict = result.Verdict
+	responseMessage.Stdout = result.Stdout
+	responseMessage.Stderr = result.Stderr
+	responseMessage.ExecTime = strconv.Itoa(result.ExecTime)
 
-	_, err = pgPool.Exec(ctx, updateQuery, outputString, status, submissionId)
+	err = sendCompilationResponseMessage(deps.Broker, job, responseMessage)
 	if err != nil {
-		log.Printf("Failed to update submission: %v\n", err)
+		log.Printf("Failed to send a compilation response message: %v", err)
 	}
 
-	responseMessage := CompilationResponseMessage{
-		SubmissionId: submissionId,
-		SocketId:     socketId,
-		Username:     username,
-		RoomId:       roomId,
-		Language:     language,
-		Type:         runType,
-		Status:       status,
-		Stdout:       stdout,
-		Stderr:       stderr,
-		ExecTime:     strconv.Itoa(execTime),
+	if deps.codeReviews {
+		requestCodeReview(deps.Broker, job, data, result)
+	}
+	return 0
+}
+
+// finishJob stores the final status of a job that didn't run to completion
+// and tells the client about it. It does nothing if another worker took over
+// the submission.
+func finishJob(ctx context.Context, b broker.Broker, job *compilationJob, status string, reason string) {
+	verdict := models.VerdictError
+	if status == "CANCELLED" {
+		verdict = models.VerdictCancelled
 	}
 
-	err = sendCompilationResponseMessage(responseMessage)
-	if err != nil {
+	if job.lease != nil {
+		result := &models.SubmissionResult{Verdict: verdict, Error: reason}
+
+		owned, err := job.lease.Complete(ctx, result, status)
+		if err != nil {
+			log.Printf("Failed to mark submission as %s: %v\n", status, err)
+			return
+		}
+		if !owned {
+			return
+		}
+	}
+
+	response := job.response(status)
+	response.Verdict = verdict
+	response.Stderr = reason
+	if err := sendCompilationResponseMessage(b, job, response); err != nil {
 		log.Printf("Failed to send a compilation response message: %v", err)
 	}
 }
 
-func SpawnCompilationWorker(id int, msgs <-chan ampq.Delivery) {
-	for msg := range msgs {
-		log.Printf("[Compilation Worker %d] Received message: %s", id, msg.Body)
+func failJob(ctx context.Context, b broker.Broker, job *compilationJob, reason string) {
+	finishJob(ctx, b, job, "FAILED", reason)
+}
+
+func cancelJob(ctx context.Context, b broker.Broker, job *compilationJob) {
+	log.Printf("Submission %s was cancelled\n", job.SubmissionId)
+	finishJob(ctx, b, job, "CANCELLED", "cancelled")
+}
 
-		processCompilationRequest(msg)
+func SpawnCompilationWorker(id int, deps *CompilationDeps, msgs <-chan broker.Delivery) {
+	for msg := range msgs {
+		log.Printf("[Compilation Worker %d] Received message: %s", id, msg.Message().Body)
+
+		if delay := processCompilationRequest(id, deps, msg); delay > 0 {
+			// Requeue without holding up the worker.
+			time.AfterFunc(delay, func() {
+				if err := msg.Nack(true); err != nil {
+					log.Printf("[Compilation Worker %d] Failed to nack message: %v", id, err)
+				}
+			})
+			continue
+		}
 
-		if err := msg.Ack(false); err != nil {
+		if err := msg.Ack(); err != nil {
 			log.Printf("[Compilation Worker %d] Failed to ack message: %v", id, err)
 		} else {
 			log.Printf("[Compilation Worker %d] Message ack'd", id)
@@ -0,0 +1,283 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+)
+
+type compilationEvent struct {
+	Event          string `json:"event"`
+	SubmissionId   string `json:"submissionId"`
+	Status         string `json:"status"`
+	Verdict        string `json:"verdict"`
+	TestCase       int    `json:"testCase"`
+	TotalTestCases int    `json:"totalTestCases"`
+}
+
+type compilationPipeline struct {
+	broker      *broker.MemoryBroker
+	submissions *repository.MemorySubmissionRepository
+	responses   <-chan broker.Delivery
+}
+
+// startCompilationPipeline runs the compilation workers against the in-memory
+// broker and repositories, in a lane so QUEUED events are published too.
+func startCompilationPipeline(t *testing.T, execute Executor) *compilationPipeline {
+	t.Helper()
+	t.Setenv("COMPILATION_LANES", "default:compilation_requests_default:1")
+	t.Setenv("COMPILATION_PROGRESS_EVENTS", "true")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	b := broker.NewMemoryBroker()
+	t.Cleanup(func() { b.Close() })
+
+	problems := repository.NewMemoryProblemRepository()
+	problems.Put(&models.Problem{
+		ID:         1,
+		Args:       map[string]string{"a": "int", "b": "int"},
+		ReturnType: "int",
+		JudgeTestCases: []models.TestCase{
+			{Input: bson.M{"a": 1, "b": 2}, Output: 3},
+			{Input: bson.M{"a": 2, "b": 2}, Output: 4},
+		},
+	})
+
+	pipeline := &compilationPipeline{broker: b, submissions: repository.NewMemorySubmissionRepository()}
+
+	deps := &CompilationDeps{
+		Broker:      b,
+		Submissions: pipeline.submissions,
+		Problems:    problems,
+		Execute:     execute,
+	}
+	if err := StartCompilationWorkers(ctx, deps, 1); err != nil {
+		t.Fatalf("StartCompilationWorkers: %v", err)
+	}
+
+	responses, err := b.Consume(ctx, CompilationResponsesQueue, broker.ConsumeOptions{})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	pipeline.responses = responses
+
+	return pipeline
+}
+
+func (p *compilationPipeline) submit(t *testing.T, submissionId string) {
+	t.Helper()
+
+	p.submissions.Put(repository.Submission{
+		SubmissionId: submissionId,
+		ProblemId:    1,
+		Language:     "python",
+		Code:         "def add(a, b): return a + b",
+		RunType:      "submit",
+	})
+
+	message := CompilationRequestMessage{SubmissionId: submissionId, SocketId: "socket-1"}
+	if err := PublishCompilationRequest(context.Background(), p.broker, message); err != nil {
+		t.Fatalf("PublishCompilationRequest: %v", err)
+	}
+}
+
+// next returns the next event on compilation_responses.
+func (p *compilationPipeline) next(t *testing.T) compilationEvent {
+	t.Helper()
+
+	select {
+	case msg := <-p.responses:
+		msg.Ack()
+		var event compilationEvent
+		if err := json.Unmarshal(msg.Message().Body, &event); err != nil {
+			t.Fatalf("invalid response %s: %v", msg.Message().Body, err)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a response")
+		return compilationEvent{}
+	}
+}
+
+// events returns the events up to and including FINISHED.
+func (p *compilationPipeline) events(t *testing.T) []compilationEvent {
+	t.Helper()
+
+	var events []compilationEvent
+	for {
+		event := p.next(t)
+		events = append(events, event)
+		if event.Event == EventFinished {
+			return events
+		}
+	}
+}
+
+func eventNames(events []compilationEvent) []string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = event.Event
+	}
+	return names
+}
+
+func TestCompilationPipelineAccepted(t *testing.T) {
+	pipeline := startCompilationPipeline(t, func(ctx context.Context, language string, wrappedCode string) (Execution, error) {
+		return Execution{Stdout: "3\n4\n", ExecTime: 12}, nil
+	})
+
+	pipeline.submit(t, "accepted")
+	events := pipeline.events(t)
+
+	want := []string{EventQueued, EventCompiling, EventRunning, EventCaseVerdict, EventCaseVerdict, EventFinished}
+	if got := eventNames(events); len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("events = %v, want %v", got, want)
+			}
+		}
+	}
+
+	if events[2].TotalTestCases != 2 {
+		t.Errorf("RUNNING totalTestCases = %d, want 2", events[2].TotalTestCases)
+	}
+	for i, event := range events[3:5] {
+		if event.TestCase != i+1 || event.Verdict != "PASSED" {
+			t.Errorf("CASE_VERDICT %d = %+v, want testCase %d PASSED", i, event, i+1)
+		}
+	}
+
+	finished := events[5]
+	if finished.Status != "SUCCEEDED" || finished.Verdict != models.VerdictAccepted {
+		t.Errorf("FINISHED = %+v, want SUCCEEDED and ACCEPTED", finished)
+	}
+
+	stored, err := pipeline.submissions.Get(context.Background(), "accepted")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Status != "SUCCEEDED" || stored.Result == nil || stored.Result.Verdict != models.VerdictAccepted {
+		t.Fatalf("stored submission = %+v, want an ACCEPTED result", stored)
+	}
+	if len(stored.Result.TestResults) != 2 || stored.Result.ExecTime != 12 {
+		t.Errorf("stored result = %+v, want 2 test results and an exec time of 12", stored.Result)
+	}
+}
+
+func TestCompilationPipelineWrongAnswer(t *testing.T) {
+	pipeline := startCompilationPipeline(t, func(ctx context.Context, language string, wrappedCode string) (Execution, error) {
+		return Execution{Stdout: "3\n5\n"}, nil
+	})
+
+	pipeline.submit(t, "wrong")
+	events := pipeline.events(t)
+
+	if finished := events[len(events)-1]; finished.Verdict != models.VerdictWrongAnswer {
+		t.Errorf("FINISHED verdict = %q, want %q", finished.Verdict, models.VerdictWrongAnswer)
+	}
+
+	stored, err := pipeline.submissions.Get(context.Background(), "wrong")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Result == nil || len(stored.Result.TestResults) != 2 || stored.Result.TestResults[1].Passed {
+		t.Fatalf("stored result = %+v, want the second test case failed", stored.Result)
+	}
+}
+
+func TestCompilationPipelineCancelWhileRunning(t *testing.T) {
+	started := make(chan struct{})
+	pipeline := startCompilationPipeline(t, func(ctx context.Context, language string, wrappedCode string) (Execution, error) {
+		close(started)
+		<-ctx.Done()
+		return Execution{}, ctx.Err()
+	})
+
+	pipeline.submit(t, "cancelled")
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the submission to run")
+	}
+
+	cancel, _ := json.Marshal(CancelMessage{SubmissionId: "cancelled"})
+	if err := pipeline.broker.Broadcast(context.Background(), CompilationControlExchange, broker.Message{Body: cancel}); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+
+	events := pipeline.events(t)
+	if finished := events[len(events)-1]; finished.Status != "CANCELLED" || finished.Verdict != models.VerdictCancelled {
+		t.Errorf("FINISHED = %+v, want CANCELLED", finished)
+	}
+
+	stored, err := pipeline.submissions.Get(context.Background(), "cancelled")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Status != "CANCELLED" {
+		t.Errorf("stored status = %q, want CANCELLED", stored.Status)
+	}
+}
+
+func TestCompilationPipelineCancelBeforeClaim(t *testing.T) {
+	pipeline := startCompilationPipeline(t, func(ctx context.Context, language string, wrappedCode string) (Execution, error) {
+		t.Error("a cancelled submission was executed")
+		return Execution{}, nil
+	})
+
+	// A cancel for a submission no worker has started is kept pending.
+	cancellations.Cancel(CancelMessage{SubmissionId: "pending"})
+	pipeline.submit(t, "pending")
+
+	events := pipeline.events(t)
+	if finished := events[len(events)-1]; finished.Status != "CANCELLED" {
+		t.Errorf("FINISHED = %+v, want CANCELLED", finished)
+	}
+
+	stored, err := pipeline.submissions.Get(context.Background(), "pending")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Status != "CANCELLED" {
+		t.Errorf("stored status = %q, want CANCELLED", stored.Status)
+	}
+}
+
+func TestCompilationPipelineInlineReplyTo(t *testing.T) {
+	pipeline := startCompilationPipeline(t, addOneAndTwo)
+
+	replies, err := pipeline.broker.Consume(context.Background(), "replies", broker.ConsumeOptions{})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	pipeline.submitInline(t, "correlation-1", "replies")
+
+	select {
+	case msg := <-replies:
+		msg.Ack()
+		var reply compilationEvent
+		if err := json.Unmarshal(msg.Message().Body, &reply); err != nil {
+			t.Fatalf("invalid reply %s: %v", msg.Message().Body, err)
+		}
+		if msg.Message().CorrelationId != "correlation-1" {
+			t.Errorf("reply correlation id = %q, want correlation-1", msg.Message().CorrelationId)
+		}
+		if reply.Event != EventFinished || reply.SubmissionId != "correlation-1" || reply.Verdict != models.VerdictAccepted {
+			t.Errorf("reply = %+v, want the accepted FINISHED response", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reply")
+	}
+}
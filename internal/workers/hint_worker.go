@@ -0,0 +1,299 @@
+package workers
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+	"octree.io-worker/internal/utils"
+)
+
+const (
+	hintRequestsQueue  = "hint_requests"
+	HintResponsesQueue = "hint_responses"
+
+	EventHint = "HINT"
+)
+
+// HintRequestMessage asks for the next hint on a problem, with the user's
+// current code and the verdict of their last submission, if any.
+type HintRequestMessage struct {
+	RequestId string `json:"requestId"`
+	SocketId  string `json:"socketId"`
+	RoomId    string `json:"roomId"`
+	Username  string `json:"username"`
+	ProblemId int    `json:"problemId"`
+	Language  string `json:"language"`
+	Code      string `json:"code"`
+	Verdict   string `json:"verdict,omitempty"`
+}
+
+// HintResponseMessage is published with the HINT event. Level is the hint's
+// level and Tier its name.
+type HintResponseMessage struct {
+	RequestId string `json:"requestId"`
+	SocketId  string `json:"socketId"`
+	RoomId    string `json:"roomId"`
+	Username  string `json:"username"`
+	ProblemId int    `json:"problemId"`
+	Status    string `json:"status"`
+	Level     int    `json:"level,omitempty"`
+	Tier      string `json:"tier,omitempty"`
+	Hint      string `json:"hint,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Event     string `json:"event"`
+}
+
+// HintRequest is what a hint is generated from.
+type HintRequest struct {
+	ProblemTitle     string
+	ProblemStatement string
+	Language         string
+	Code             string
+	Verdict          string
+	Level            int
+}
+
+// Hinter is implemented by graders that can give hints.
+type Hinter interface {
+	Hint(ctx context.Context, request HintRequest) (string, error)
+}
+
+// HintDeps are the services the hint pipeline depends on. Hinter defaults to
+// the grader LoadLLMConfig selects.
+type HintDeps struct {
+	Broker   broker.Broker
+	Problems repository.ProblemRepository
+	Levels   repository.HintLevelRepository
+	Hinter   Hinter
+}
+
+func parseHintRequest(body []byte) (HintRequestMessage, error) {
+	var message HintRequestMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return message, err
+	}
+
+	if message.RequestId == "" {
+		return message, errors.New("requestId is missing or empty")
+	}
+	if message.ProblemId == 0 {
+		return message, errors.New("problemId is missing")
+	}
+	if message.Username == "" && message.SocketId == "" {
+		return message, errors.New("username and socketId are missing")
+	}
+
+	return message, nil
+}
+
+// sendHintResponseMessage publishes the response to the request's ReplyTo
+// queue if it has one, and to hint_responses otherwise.
+func sendHintResponseMessage(b broker.Broker, delivery broker.Message, response HintResponseMessage) error {
+	if delivery.ReplyTo != "" {
+		return publishResponse(b, delivery.ReplyTo, delivery.CorrelationId, response)
+	}
+	return publishResponse(b, HintResponsesQueue, "", response)
+}
+
+// nextHint generates the hint after the last one the user got for the
+// problem. The level is taken before the hint is generated, so concurrent
+// requests get different hints, and given back if no hint is.
+func nextHint(ctx context.Context, deps *HintDeps, user string, problem *models.Problem, message HintRequestMessage) (int, string, error) {
+	level, err := deps.Levels.NextHintLevel(ctx, user, problem.ID, models.HintPseudocode)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hint, err := deps.Hinter.Hint(ctx, HintRequest{
+		ProblemTitle:     problem.Title,
+		ProblemStatement: problem.Description,
+		Language:         message.Language,
+		Code:             message.Code,
+		Verdict:          message.Verdict,
+		Level:            level,
+	})
+	if err != nil {
+		if err := deps.Levels.UndoHintLevel(context.WithoutCancel(ctx), user, problem.ID, level); err != nil {
+			log.Printf("Failed to undo hint level of %s for problem %d: %v", user, problem.ID, err)
+		}
+		return 0, "", err
+	}
+
+	return level, hint, nil
+}
+
+func processHintRequest(deps *HintDeps, msg broker.Delivery) {
+	delivery := msg.Message()
+
+	message, err := parseHintRequest(delivery.Body)
+	if err != nil {
+		log.Printf("Invalid hint request: %v\n", err)
+		return
+	}
+
+	// Anonymous users are tracked per socket.
+	user := message.Username
+	if user == "" {
+		user = "socket:" + message.SocketId
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), utils.GetEnvDuration("HINT_TIMEOUT", 2*time.Minute))
+	defer cancel()
+
+	response := HintResponseMessage{
+		RequestId: message.RequestId,
+		SocketId:  message.SocketId,
+		RoomId:    message.RoomId,
+		Username:  message.Username,
+		ProblemId: message.ProblemId,
+		Status:    "SUCCEEDED",
+		Event:     EventHint,
+	}
+
+	problem, err := deps.Problems.GetByID(ctx, message.ProblemId)
+	if err != nil {
+		log.Printf("Error finding problem: %v", err)
+		response.Status = "FAILED"
+		response.Error = problemError(err)
+	} else if level, hint, err := nextHint(ctx, deps, user, problem, message); err != nil {
+		log.Printf("Failed to give hint %s: %v\n", message.RequestId, err)
+		response.Status = "FAILED"
+		response.Error = "hint failed"
+	} else {
+		response.Level = level
+		response.Tier = models.HintTier(level)
+		response.Hint = hint
+	}
+
+	if err := sendHintResponseMessage(deps.Broker, delivery, response); err != nil {
+		log.Printf("Failed to send a hint response message: %v", err)
+	}
+}
+
+func SpawnHintWorker(id int, deps *HintDeps, msgs <-chan broker.Delivery) {
+	for msg := range msgs {
+		log.Printf("[Hint Worker %d] Received message: %s", id, msg.Message().Body)
+
+		processHintRequest(deps, msg)
+
+		if err := msg.Ack(); err != nil {
+			log.Printf("[Hint Worker %d] Failed to ack message: %v", id, err)
+		}
+	}
+}
+
+//go:embed prompts/hint.tmpl
+var hintTemplateText string
+
+var hintTemplate = template.Must(template.New("hint").Parse(hintTemplateText))
+
+// hintOutput is the JSON object the model gives a hint with.
+type hintOutput struct {
+	Hint string `json:"hint" description:"The hint, addressed to the user"`
+}
+
+var hintSchema = mustGenerateSchema(hintOutput{})
+
+// containsCode reports whether a Markdown hint has a fenced or indented code
+// block or inline code. Indented list items are pseudo-code steps, not code.
+func containsCode(hint string) bool {
+	if strings.Contains(hint, "`") || strings.Contains(hint, "~~~") {
+		return true
+	}
+
+	afterBlank := true
+	for _, line := range strings.Split(hint, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			afterBlank = true
+			continue
+		}
+		indented := strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+		if indented && afterBlank && !isListItem(trimmed) {
+			return true
+		}
+		afterBlank = false
+	}
+	return false
+}
+
+// isListItem reports whether a trimmed line starts a Markdown list item.
+func isListItem(line string) bool {
+	if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "+ ") {
+		return true
+	}
+	digits := len(line) - len(strings.TrimLeft(line, "0123456789"))
+	return digits > 0 && (strings.HasPrefix(line[digits:], ". ") || strings.HasPrefix(line[digits:], ") "))
+}
+
+func (g *OpenAIGrader) Hint(ctx context.Context, request HintRequest) (string, error) {
+	var prompt strings.Builder
+	if err := hintTemplate.Execute(&prompt, request); err != nil {
+		return "", fmt.Errorf("failed to render hint prompt: %w", err)
+	}
+
+	var output hintOutput
+	err := g.complete(ctx, "hint", "", hintSchema, prompt.String(), func(content string) error {
+		if err := hintSchema.Unmarshal(content, &output); err != nil {
+			return fmt.Errorf("%w: %v", errMalformedGrade, err)
+		}
+		if strings.TrimSpace(output.Hint) == "" {
+			return fmt.Errorf("%w: hint is empty", errMalformedGrade)
+		}
+		// Hints are prose or pseudo-code, so code gives too much away.
+		if containsCode(output.Hint) {
+			return fmt.Errorf("%w: hint contains code", errMalformedGrade)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(output.Hint), nil
+}
+
+// Hint names the level it was asked for, so hints can be checked without a
+// model.
+func (FakeGrader) Hint(ctx context.Context, request HintRequest) (string, error) {
+	return fmt.Sprintf("%s hint for %q.", models.HintTier(request.Level), request.ProblemTitle), nil
+}
+
+// StartHintWorkers declares the hint queues and starts count workers. The
+// workers stop when ctx is done.
+func StartHintWorkers(ctx context.Context, deps *HintDeps, count int) error {
+	if deps.Hinter == nil {
+		grader, err := NewLLMGrader(LoadLLMConfig())
+		if err != nil {
+			return err
+		}
+		deps.Hinter = grader
+	}
+
+	for _, queue := range []string{hintRequestsQueue, HintResponsesQueue} {
+		if err := deps.Broker.DeclareQueue(queue, broker.QueueOptions{}); err != nil {
+			return err
+		}
+	}
+
+	msgs, err := deps.Broker.Consume(ctx, hintRequestsQueue, broker.ConsumeOptions{})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		go SpawnHintWorker(i, deps, msgs)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+)
+
+type hintPipeline struct {
+	broker    *broker.MemoryBroker
+	levels    *repository.MemoryHintLevelRepository
+	responses <-chan broker.Delivery
+}
+
+// startHintPipeline runs a hint worker with hinter against the in-memory
+// broker and repositories.
+func startHintPipeline(t *testing.T, hinter Hinter) *hintPipeline {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	b := broker.NewMemoryBroker()
+	t.Cleanup(func() { b.Close() })
+
+	problems := repository.NewMemoryProblemRepository()
+	problems.Put(&models.Problem{
+		ID:          1,
+		Title:       "Two Sum",
+		Description: "Add two numbers.",
+		Args:        map[string]string{"a": "int", "b": "int"},
+		ReturnType:  "int",
+		JudgeTestCases: []models.TestCase{
+			{Input: bson.M{"a": 1, "b": 2}, Output: 3},
+		},
+	})
+
+	pipeline := &hintPipeline{broker: b, levels: repository.NewMemoryHintLevelRepository()}
+
+	deps := &HintDeps{
+		Broker:   b,
+		Problems: problems,
+		Levels:   pipeline.levels,
+		Hinter:   hinter,
+	}
+	if err := StartHintWorkers(ctx, deps, 1); err != nil {
+		t.Fatalf("StartHintWorkers: %v", err)
+	}
+
+	responses, err := b.Consume(ctx, HintResponsesQueue, broker.ConsumeOptions{})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	pipeline.responses = responses
+
+	return pipeline
+}
+
+func (p *hintPipeline) request(t *testing.T, message HintRequestMessage, replyTo string, correlationId string) {
+	t.Helper()
+
+	body, _ := json.Marshal(message)
+	err := p.broker.Publish(context.Background(), hintRequestsQueue, broker.Message{Body: body, ReplyTo: replyTo, CorrelationId: correlationId})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+// nextHintResponse returns the next response on responses.
+func nextHintResponse(t *testing.T, responses <-chan broker.Delivery) (HintResponseMessage, broker.Message) {
+	t.Helper()
+
+	select {
+	case msg := <-responses:
+		msg.Ack()
+		var response HintResponseMessage
+		if err := json.Unmarshal(msg.Message().Body, &response); err != nil {
+			t.Fatalf("invalid response %s: %v", msg.Message().Body, err)
+		}
+		return response, msg.Message()
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a hint")
+		return HintResponseMessage{}, broker.Message{}
+	}
+}
+
+func TestHintPipelineLevels(t *testing.T) {
+	pipeline := startHintPipeline(t, FakeGrader{})
+
+	// Every request gets the next level, and the last one once there are
+	// no more.
+	for i, want := range []int{models.HintNudge, models.HintApproach, models.HintPseudocode, models.HintPseudocode} {
+		pipeline.request(t, HintRequestMessage{RequestId: "hint", Username: "alice", ProblemId: 1}, "", "")
+
+		response, _ := nextHintResponse(t, pipeline.responses)
+		if response.Status != "SUCCEEDED" || response.Event != EventHint || response.Level != want || response.Tier != models.HintTier(want) {
+			t.Errorf("response %d = %+v, want a %s hint", i, response, models.HintTier(want))
+		}
+		if wantHint, _ := (FakeGrader{}).Hint(context.Background(), HintRequest{ProblemTitle: "Two Sum", Level: want}); response.Hint != wantHint {
+			t.Errorf("hint %d = %q, want %q", i, response.Hint, wantHint)
+		}
+	}
+
+	// Levels are per user.
+	pipeline.request(t, HintRequestMessage{RequestId: "anonymous", SocketId: "socket-1", ProblemId: 1}, "", "")
+	if response, _ := nextHintResponse(t, pipeline.responses); response.Level != models.HintNudge {
+		t.Errorf("level of another user = %d, want %d", response.Level, models.HintNudge)
+	}
+}
+
+func TestHintPipelineUnknownProblem(t *testing.T) {
+	pipeline := startHintPipeline(t, FakeGrader{})
+
+	pipeline.request(t, HintRequestMessage{RequestId: "unknown", Username: "alice", ProblemId: 42}, "", "")
+
+	response, _ := nextHintResponse(t, pipeline.responses)
+	if response.RequestId != "unknown" || response.Status != "FAILED" || response.Error == "" || response.Hint != "" {
+		t.Errorf("response = %+v, want FAILED without a hint", response)
+	}
+}
+
+func TestHintPipelineReplyTo(t *testing.T) {
+	pipeline := startHintPipeline(t, FakeGrader{})
+
+	replies, err := pipeline.broker.Consume(context.Background(), "hint-replies", broker.ConsumeOptions{})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	pipeline.request(t, HintRequestMessage{RequestId: "inline", Username: "alice", ProblemId: 1}, "hint-replies", "correlation-1")
+
+	response, reply := nextHintResponse(t, replies)
+	if reply.CorrelationId != "correlation-1" {
+		t.Errorf("CorrelationId = %q, want correlation-1", reply.CorrelationId)
+	}
+	if response.RequestId != "inline" || response.Status != "SUCCEEDED" || response.Level != models.HintNudge {
+		t.Errorf("reply = %+v, want a SUCCEEDED NUDGE", response)
+	}
+	if n := pipeline.broker.Len(HintResponsesQueue); n != 0 {
+		t.Errorf("%d responses on %s, want the reply only", n, HintResponsesQueue)
+	}
+}
+
+// flakyHinter fails its first hint.
+type flakyHinter struct {
+	failed chan struct{}
+}
+
+func (h flakyHinter) Hint(ctx context.Context, request HintRequest) (string, error) {
+	select {
+	case h.failed <- struct{}{}:
+		return "", errors.New("provider is down")
+	default:
+		return FakeGrader{}.Hint(ctx, request)
+	}
+}
+
+func TestHintPipelineFailedHintKeepsLevel(t *testing.T) {
+	pipeline := startHintPipeline(t, flakyHinter{failed: make(chan struct{}, 1)})
+
+	pipeline.request(t, HintRequestMessage{RequestId: "failed", Username: "alice", ProblemId: 1}, "", "")
+	if response, _ := nextHintResponse(t, pipeline.responses); response.Status != "FAILED" || response.Level != 0 {
+		t.Fatalf("response = %+v, want FAILED", response)
+	}
+
+	pipeline.request(t, HintRequestMessage{RequestId: "retried", Username: "alice", ProblemId: 1}, "", "")
+	if response, _ := nextHintResponse(t, pipeline.responses); response.Status != "SUCCEEDED" || response.Level != models.HintNudge {
+		t.Errorf("response = %+v, want the NUDGE the failed request didn't get", response)
+	}
+}
+
+func TestContainsCode(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		hint string
+		want bool
+	}{
+		{"prose", "Think about what you have already seen.", false},
+		{"pseudo-code", "1. Walk the list.\n2. For each number:\n    1. Look up its complement.\n    2. Remember the number.", false},
+		{"indented list after a blank line", "Steps:\n\n    - Sort the numbers.\n    - Move two pointers inward.", false},
+		{"fenced code", "Try this:\n```python\nreturn a + b\n```", true},
+		{"tilde fenced code", "Try this:\n~~~\nreturn a + b\n~~~", true},
+		{"inline code", "Use `seen[target - n]` to find it.", true},
+		{"indented code", "Try this:\n\n    return a + b", true},
+		{"tab indented code", "\treturn a + b", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := containsCode(test.hint); got != test.want {
+				t.Errorf("containsCode(%q) = %v, want %v", test.hint, got, test.want)
+			}
+		})
+	}
+}
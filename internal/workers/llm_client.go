@@ -0,0 +1,252 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrLLMUnavailable is returned when the provider kept failing with rate
+// limits, server errors or timeouts after every retry.
+var ErrLLMUnavailable = errors.New("LLM provider unavailable")
+
+// llmMetrics are published on /debug/vars under "llm".
+var llmMetrics = expvar.NewMap("llm")
+
+// LLMLimits bound and price the chat completions an LLMClient makes.
+type LLMLimits struct {
+	// RequestsPerMinute and TokensPerMinute are 0 for no limit.
+	RequestsPerMinute int
+	TokensPerMinute   int
+
+	// MaxRetries bounds the retries of a completion failing with a rate
+	// limit, a server error or a timeout.
+	MaxRetries int
+	Timeout    time.Duration
+
+	// Costs are in USD per million tokens.
+	InputCostPerMTok     float64
+	OutputCostPerMTok    float64
+	EmbeddingCostPerMTok float64
+}
+
+// minuteWindow limits the requests and tokens spent over the last minute.
+type minuteWindow struct {
+	requests int
+	tokens   int
+
+	mu     sync.Mutex
+	spent  []windowEntry
+	nextId uint64
+}
+
+type windowEntry struct {
+	id     uint64
+	at     time.Time
+	tokens int
+}
+
+// wait blocks until a request estimated to use tokens fits in the window,
+// and records it.
+func (w *minuteWindow) wait(ctx context.Context, tokens int) (uint64, error) {
+	for {
+		w.mu.Lock()
+		now := time.Now()
+		for len(w.spent) > 0 && now.Sub(w.spent[0].at) >= time.Minute {
+			w.spent = w.spent[1:]
+		}
+
+		used := 0
+		for _, entry := range w.spent {
+			used += entry.tokens
+		}
+
+		// A request larger than the whole budget waits for an empty window.
+		fits := (w.requests == 0 || len(w.spent) < w.requests) &&
+			(w.tokens == 0 || used+tokens <= w.tokens || len(w.spent) == 0)
+		if fits {
+			w.nextId++
+			w.spent = append(w.spent, windowEntry{id: w.nextId, at: now, tokens: tokens})
+			w.mu.Unlock()
+			return w.nextId, nil
+		}
+
+		delay := time.Minute - now.Sub(w.spent[0].at)
+		w.mu.Unlock()
+
+		llmMetrics.Add("throttled", 1)
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// settle replaces the estimate of a request with the tokens it used.
+func (w *minuteWindow) settle(id uint64, tokens int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := range w.spent {
+		if w.spent[i].id == id {
+			w.spent[i].tokens = tokens
+			return
+		}
+	}
+}
+
+// LLMClient makes chat completions within the rate limits, retrying rate
+// limits, server errors and timeouts with jittered exponential backoff, and
+// accounts for their tokens and cost.
+type LLMClient struct {
+	client *openai.Client
+	limits LLMLimits
+	window *minuteWindow
+}
+
+func NewLLMClient(clientConfig openai.ClientConfig, limits LLMLimits) *LLMClient {
+	if limits.Timeout <= 0 {
+		limits.Timeout = time.Minute
+	}
+
+	return &LLMClient{
+		client: openai.NewClientWithConfig(clientConfig),
+		limits: limits,
+		window: &minuteWindow{requests: limits.RequestsPerMinute, tokens: limits.TokensPerMinute},
+	}
+}
+
+// estimateTokens guesses the tokens of a request before sending it, at four
+// characters per token plus room for the completion.
+func estimateTokens(request openai.ChatCompletionRequest) int {
+	tokens := 512
+	for _, message := range request.Messages {
+		tokens += len(message.Content) / 4
+	}
+	return tokens
+}
+
+var statusCodePattern = regexp.MustCompile(`^error, status code: (\d+),`)
+
+func retryableLLMError(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == "insufficient_quota" {
+			return false
+		}
+		return apiErr.HTTPStatusCode == 429 || apiErr.HTTPStatusCode >= 500
+	}
+
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.HTTPStatusCode == 429 || requestErr.HTTPStatusCode >= 500
+	}
+
+	// Error responses that aren't JSON, like a gateway's 502 page, are only
+	// described by the error message.
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		return status == 429 || status >= 500
+	}
+
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func backoff(attempt int) time.Duration {
+	delay := time.Second << min(attempt, 5)
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+// call makes a request estimated to use estimate tokens with fn, within the
+// rate limits and retrying failures that may pass, and accounts for the
+// usage fn returns at the given costs per million tokens.
+func (c *LLMClient) call(ctx context.Context, model string, estimate int, inputCost float64, outputCost float64, fn func(ctx context.Context) (openai.Usage, error)) error {
+	for attempt := 0; ; attempt++ {
+		id, err := c.window.wait(ctx, estimate)
+		if err != nil {
+			return err
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, c.limits.Timeout)
+		start := time.Now()
+		usage, err := fn(attemptCtx)
+		cancel()
+
+		llmMetrics.Add("requests", 1)
+		if err == nil {
+			if usage.TotalTokens > 0 {
+				c.window.settle(id, usage.TotalTokens)
+			}
+			account(model, usage, inputCost, outputCost, time.Since(start))
+			return nil
+		}
+
+		llmMetrics.Add("failures", 1)
+		c.window.settle(id, 0)
+		if ctx.Err() != nil || !retryableLLMError(err) {
+			return err
+		}
+		if attempt >= c.limits.MaxRetries {
+			return fmt.Errorf("%w: %v", ErrLLMUnavailable, err)
+		}
+
+		delay := backoff(attempt)
+		log.Printf("%s request failed: %v, retrying in %v (retry %d/%d)", model, err, delay, attempt+1, c.limits.MaxRetries)
+		llmMetrics.Add("retries", 1)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *LLMClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	var resp openai.ChatCompletionResponse
+	err := c.call(ctx, request.Model, estimateTokens(request), c.limits.InputCostPerMTok, c.limits.OutputCostPerMTok, func(ctx context.Context) (openai.Usage, error) {
+		var err error
+		resp, err = c.client.CreateChatCompletion(ctx, request)
+		return resp.Usage, err
+	})
+	return resp, err
+}
+
+// Embed returns the embedding of text.
+func (c *LLMClient) Embed(ctx context.Context, model string, text string) ([]float32, error) {
+	var resp openai.EmbeddingResponse
+	err := c.call(ctx, model, len(text)/4+1, c.limits.EmbeddingCostPerMTok, 0, func(ctx context.Context) (openai.Usage, error) {
+		var err error
+		resp, err = c.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{Input: []string{text}, Model: openai.EmbeddingModel(model)})
+		return resp.Usage, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.New("embedding request returned no embeddings")
+	}
+	return resp.Data[0].Embedding, nil
+}
+
+func account(model string, usage openai.Usage, inputCost float64, outputCost float64, took time.Duration) {
+	cost := (float64(usage.PromptTokens)*inputCost + float64(usage.CompletionTokens)*outputCost) / 1e6
+
+	llmMetrics.Add("promptTokens", int64(usage.PromptTokens))
+	llmMetrics.Add("completionTokens", int64(usage.CompletionTokens))
+	llmMetrics.AddFloat("costUsd", cost)
+
+	log.Printf("%s request took %v: %d prompt tokens, %d completion tokens, $%.6f", model, took, usage.PromptTokens, usage.CompletionTokens, cost)
+}
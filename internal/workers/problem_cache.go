@@ -0,0 +1,246 @@
+package workers
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+	"octree.io-worker/internal/utils"
+)
+
+type ProblemCacheConfig struct {
+	// Size is the maximum number of cached problems; 0 disables the cache.
+	Size         int
+	TTL          time.Duration
+	PollInterval time.Duration
+}
+
+func LoadProblemCacheConfig() ProblemCacheConfig {
+	return ProblemCacheConfig{
+		Size:         utils.GetEnvInt("PROBLEM_CACHE_SIZE", 256),
+		TTL:          utils.GetEnvDuration("PROBLEM_CACHE_TTL", 10*time.Minute),
+		PollInterval: utils.GetEnvDuration("PROBLEM_CACHE_POLL_INTERVAL", 30*time.Second),
+	}
+}
+
+// problemKey identifies a cached problem. Version 0 is the current problem,
+// which changes when it is edited; other versions are immutable revisions.
+type problemKey struct {
+	id      int
+	version int
+}
+
+type cachedProblem struct {
+	key      problemKey
+	problem  *models.Problem
+	run      *testData
+	submit   *testData
+	loadedAt time.Time
+}
+
+// ProblemCache keeps the most recently used problems together with their
+// converted test cases and per-language harness templates, so submissions
+// don't query MongoDB and rebuild the harness every time.
+//
+// Entries expire after TTL. Edits are picked up from the repository's change
+// stream when it has one, and by polling the cached problems otherwise.
+type ProblemCache struct {
+	problems repository.ProblemRepository
+	config   ProblemCacheConfig
+
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[problemKey]*list.Element
+	lru     *list.List
+	// generations count the invalidations of each problem, and purges those
+	// of every problem, so a problem fetched before it was invalidated isn't
+	// cached.
+	generations map[int]uint64
+	purges      uint64
+}
+
+func NewProblemCache(problems repository.ProblemRepository, config ProblemCacheConfig) *ProblemCache {
+	return &ProblemCache{
+		problems:    problems,
+		config:      config,
+		now:         time.Now,
+		entries:     make(map[problemKey]*list.Element),
+		lru:         list.New(),
+		generations: make(map[int]uint64),
+	}
+}
+
+// TestData returns the test data of a version of a problem for a run type.
+// Version 0 is the current problem.
+func (c *ProblemCache) TestData(ctx context.Context, problemId int, version int, runType string) (*testData, error) {
+	entry, err := c.get(ctx, problemKey{problemId, version})
+	if err != nil {
+		return nil, err
+	}
+
+	if runType == "submit" {
+		return entry.submit, nil
+	}
+	return entry.run, nil
+}
+
+func (c *ProblemCache) get(ctx context.Context, key problemKey) (*cachedProblem, error) {
+	if c.config.Size > 0 {
+		c.mu.Lock()
+		if element, ok := c.entries[key]; ok {
+			entry := element.Value.(*cachedProblem)
+			if c.config.TTL <= 0 || c.now().Sub(entry.loadedAt) < c.config.TTL {
+				c.lru.MoveToFront(element)
+				c.mu.Unlock()
+				return entry, nil
+			}
+			c.remove(key)
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	generation, purges := c.generations[key.id], c.purges
+	c.mu.Unlock()
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	problem, err := c.problems.GetRevision(fetchCtx, key.id, key.version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the version submissions are judged against so they can be judged
+	// against it again after the problem is edited.
+	if key.version == 0 && problem.Version != 0 {
+		if err := c.problems.SaveRevision(fetchCtx, problem); err != nil {
+			log.Printf("Failed to save problem revision: %v", err)
+		}
+	}
+
+	entry := &cachedProblem{
+		key:      key,
+		problem:  problem,
+		run:      problemTestData(problem, "run"),
+		submit:   problemTestData(problem, "submit"),
+		loadedAt: c.now(),
+	}
+
+	if c.config.Size > 0 {
+		c.mu.Lock()
+		if c.generations[key.id] == generation && c.purges == purges {
+			c.remove(key)
+			c.entries[key] = c.lru.PushFront(entry)
+			for c.lru.Len() > c.config.Size {
+				c.remove(c.lru.Back().Value.(*cachedProblem).key)
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	return entry, nil
+}
+
+// remove must be called with mu held.
+func (c *ProblemCache) remove(key problemKey) {
+	if element, ok := c.entries[key]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+// Invalidate drops the current version of a problem.
+func (c *ProblemCache) Invalidate(problemId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generations[problemId]++
+	c.remove(problemKey{id: problemId})
+}
+
+func (c *ProblemCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purges++
+	c.entries = make(map[problemKey]*list.Element)
+	c.lru.Init()
+}
+
+func (c *ProblemCache) apply(change repository.ProblemChange) {
+	if change.All {
+		c.Purge()
+		return
+	}
+	c.Invalidate(change.ID)
+}
+
+// Watch invalidates edited problems until ctx is done.
+func (c *ProblemCache) Watch(ctx context.Context) {
+	if c.config.Size <= 0 {
+		return
+	}
+
+	if watcher, ok := c.problems.(repository.ProblemWatcher); ok {
+		err := watcher.WatchProblems(ctx, c.apply)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Problem change stream unavailable, polling every %v instead: %v", c.config.PollInterval, err)
+
+		// Edits may have been missed while the stream was down.
+		c.Purge()
+	}
+
+	if c.config.PollInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.poll(ctx)
+		}
+	}
+}
+
+// poll reloads every cached current problem and drops those that changed.
+func (c *ProblemCache) poll(ctx context.Context) {
+	c.mu.Lock()
+	cached := make([]*cachedProblem, 0, c.lru.Len())
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		if entry := element.Value.(*cachedProblem); entry.key.version == 0 {
+			cached = append(cached, entry)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, entry := range cached {
+		fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		problem, err := c.problems.GetByID(fetchCtx, entry.key.id)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, repository.ErrNotFound) && !errors.Is(err, models.ErrInvalidProblem) {
+			log.Printf("Failed to poll problem %d: %v", entry.key.id, err)
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(problem, entry.problem) {
+			c.Invalidate(entry.key.id)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+)
+
+// countingProblems counts the problems fetched from a memory repository, and
+// calls fetched after each fetch if it is set.
+type countingProblems struct {
+	*repository.MemoryProblemRepository
+	fetches atomic.Int32
+	fetched func()
+}
+
+func (r *countingProblems) GetRevision(ctx context.Context, id int, version int) (*models.Problem, error) {
+	problem, err := r.MemoryProblemRepository.GetRevision(ctx, id, version)
+	r.fetches.Add(1)
+	if r.fetched != nil {
+		r.fetched()
+	}
+	return problem, err
+}
+
+func cacheTestProblem(id int, title string) *models.Problem {
+	return &models.Problem{
+		ID:             id,
+		Title:          title,
+		Args:           map[string]string{"n": "int"},
+		ReturnType:     "int",
+		JudgeTestCases: []models.TestCase{{Input: bson.M{"n": 1}, Output: 1}},
+	}
+}
+
+func newCountingProblems(ids ...int) *countingProblems {
+	problems := &countingProblems{MemoryProblemRepository: repository.NewMemoryProblemRepository()}
+	for _, id := range ids {
+		problems.Put(cacheTestProblem(id, "v1"))
+	}
+	return problems
+}
+
+// cachedTitle returns the title of the problem the cache has for id.
+func cachedTitle(t *testing.T, cache *ProblemCache, id int) string {
+	t.Helper()
+
+	data, err := cache.TestData(context.Background(), id, 0, "submit")
+	if err != nil {
+		t.Fatalf("TestData(%d): %v", id, err)
+	}
+	return data.Title
+}
+
+func TestProblemCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	problems := newCountingProblems(1, 2, 3)
+	cache := NewProblemCache(problems, ProblemCacheConfig{Size: 2})
+
+	for _, id := range []int{1, 2, 1, 3, 1} {
+		cachedTitle(t, cache, id)
+	}
+	if n := problems.fetches.Load(); n != 3 {
+		t.Errorf("fetched %d problems, want 3", n)
+	}
+
+	// 3 pushed out 2, which was used less recently than 1.
+	cachedTitle(t, cache, 2)
+	if n := problems.fetches.Load(); n != 4 {
+		t.Errorf("fetched %d problems, want problem 2 fetched again", n)
+	}
+}
+
+func TestProblemCacheExpires(t *testing.T) {
+	problems := newCountingProblems(1)
+	cache := NewProblemCache(problems, ProblemCacheConfig{Size: 2, TTL: time.Minute})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	cachedTitle(t, cache, 1)
+	now = now.Add(30 * time.Second)
+	cachedTitle(t, cache, 1)
+	if n := problems.fetches.Load(); n != 1 {
+		t.Errorf("fetched %d problems before the TTL, want 1", n)
+	}
+
+	now = now.Add(time.Minute)
+	cachedTitle(t, cache, 1)
+	if n := problems.fetches.Load(); n != 2 {
+		t.Errorf("fetched %d problems after the TTL, want 2", n)
+	}
+}
+
+func TestProblemCacheInvalidate(t *testing.T) {
+	problems := newCountingProblems(1)
+	cache := NewProblemCache(problems, ProblemCacheConfig{Size: 2})
+
+	cachedTitle(t, cache, 1)
+	problems.Put(cacheTestProblem(1, "v2"))
+	if title := cachedTitle(t, cache, 1); title != "v1" {
+		t.Fatalf("title = %q before Invalidate, want the cached v1", title)
+	}
+
+	cache.Invalidate(1)
+	if title := cachedTitle(t, cache, 1); title != "v2" {
+		t.Errorf("title = %q after Invalidate, want v2", title)
+	}
+}
+
+func TestProblemCacheInvalidateDuringFetch(t *testing.T) {
+	problems := newCountingProblems(1)
+	cache := NewProblemCache(problems, ProblemCacheConfig{Size: 2})
+
+	// The problem is edited and invalidated after it was fetched but before
+	// it was cached.
+	problems.fetched = func() {
+		problems.fetched = nil
+		problems.Put(cacheTestProblem(1, "v2"))
+		cache.Invalidate(1)
+	}
+
+	if title := cachedTitle(t, cache, 1); title != "v1" {
+		t.Fatalf("title = %q, want v1", title)
+	}
+	if title := cachedTitle(t, cache, 1); title != "v2" {
+		t.Errorf("title = %q, want v2 rather than the v1 fetched before Invalidate", title)
+	}
+
+	problems.fetched = func() {
+		problems.fetched = nil
+		problems.Put(cacheTestProblem(1, "v3"))
+		cache.Purge()
+	}
+	cache.Invalidate(1)
+
+	cachedTitle(t, cache, 1)
+	if title := cachedTitle(t, cache, 1); title != "v3" {
+		t.Errorf("title = %q, want v3 rather than the v2 fetched before Purge", title)
+	}
+}
+
+// watchedProblems pushes the changes sent on changes, or fails to watch if
+// changes is nil.
+type watchedProblems struct {
+	*countingProblems
+	changes chan repository.ProblemChange
+}
+
+func (r *watchedProblems) WatchProblems(ctx context.Context, onChange func(repository.ProblemChange)) error {
+	if r.changes == nil {
+		return errors.New("change streams need a replica set")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case change := <-r.changes:
+			onChange(change)
+		}
+	}
+}
+
+// waitForTitle waits until the cache has title for id.
+func waitForTitle(t *testing.T, cache *ProblemCache, id int, title string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cachedTitle(t, cache, id) != title {
+		if time.Now().After(deadline) {
+			t.Fatalf("the cache doesn't have %s of problem %d", title, id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProblemCacheWatchChangeStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	problems := &watchedProblems{countingProblems: newCountingProblems(1, 2), changes: make(chan repository.ProblemChange)}
+	cache := NewProblemCache(problems, ProblemCacheConfig{Size: 2})
+	go cache.Watch(ctx)
+
+	cachedTitle(t, cache, 1)
+	cachedTitle(t, cache, 2)
+
+	problems.Put(cacheTestProblem(1, "v2"))
+	problems.changes <- repository.ProblemChange{ID: 1}
+	waitForTitle(t, cache, 1, "v2")
+	if title := cachedTitle(t, cache, 2); title != "v1" {
+		t.Errorf("title of problem 2 = %q, want the cached v1", title)
+	}
+
+	problems.Put(cacheTestProblem(2, "v2"))
+	problems.changes <- repository.ProblemChange{All: true}
+	waitForTitle(t, cache, 2, "v2")
+}
+
+func TestProblemCacheWatchPolls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Without a change stream the cache polls.
+	problems := &watchedProblems{countingProblems: newCountingProblems(1)}
+	cache := NewProblemCache(problems, ProblemCacheConfig{Size: 2, PollInterval: 10 * time.Millisecond})
+
+	cachedTitle(t, cache, 1)
+	go cache.Watch(ctx)
+
+	problems.Put(cacheTestProblem(1, "v2"))
+	waitForTitle(t, cache, 1, "v2")
+}
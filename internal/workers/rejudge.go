@@ -0,0 +1,225 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/facade"
+	"octree.io-worker/internal/repository"
+)
+
+// RejudgeOutcome is the result of judging a finished submission again.
+type RejudgeOutcome struct {
+	SubmissionId string
+	Username     string
+	RoomId       string
+	Language     string
+	OldVersion   int
+	NewVersion   int
+	OldVerdict   string
+	NewVerdict   string
+	Err          error
+}
+
+func (o RejudgeOutcome) Changed() bool {
+	return o.Err == nil && o.OldVerdict != o.NewVerdict
+}
+
+type RejudgeOptions struct {
+	// Filter selects the submissions; RunType defaults to submit.
+	Filter repository.SubmissionFilter
+
+	// Version is the problem version to judge against, 0 being the current
+	// one.
+	Version int
+
+	// Concurrency bounds the submissions judged at the same time.
+	Concurrency int
+
+	// Enqueue publishes the submissions to the compilation workers instead
+	// of executing them here, and waits up to Wait for each result.
+	Enqueue bool
+	Wait    time.Duration
+}
+
+// Rejudge judges finished submissions again and stores their new results,
+// pinned to the version they were judged against. Outcomes are in the order
+// the submissions were created.
+func Rejudge(ctx context.Context, deps *CompilationDeps, options RejudgeOptions) ([]RejudgeOutcome, error) {
+	if deps.Execute == nil {
+		deps.Execute = ExecuteCode
+	}
+	if options.Filter.RunType == "" {
+		options.Filter.RunType = "submit"
+	}
+	if options.Concurrency < 1 {
+		options.Concurrency = 1
+	}
+
+	if options.Enqueue {
+		if err := deps.Broker.DeclareQueue(compilationRequestsQueue, broker.QueueOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	submissions, err := deps.Submissions.ListFinished(ctx, options.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := NewProblemCache(deps.Problems, ProblemCacheConfig{Size: 64})
+	outcomes := make([]RejudgeOutcome, len(submissions))
+	slots := make(chan struct{}, options.Concurrency)
+
+	var wg sync.WaitGroup
+	for i, submission := range submissions {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, submission *repository.Submission) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if options.Enqueue {
+				outcomes[i] = requeueSubmission(ctx, deps, submission, options)
+			} else {
+				outcomes[i] = rejudgeSubmission(ctx, deps, problems, submission, options.Version)
+			}
+		}(i, submission)
+	}
+	wg.Wait()
+
+	// Submissions that weren't started when ctx was cancelled are left out.
+	started := outcomes[:0]
+	for _, outcome := range outcomes {
+		if outcome.SubmissionId != "" {
+			started = append(started, outcome)
+		}
+	}
+
+	return started, ctx.Err()
+}
+
+func newRejudgeOutcome(submission *repository.Submission) RejudgeOutcome {
+	outcome := RejudgeOutcome{
+		SubmissionId: submission.SubmissionId,
+		Username:     submission.Username,
+		RoomId:       submission.RoomId,
+		Language:     submission.Language,
+		OldVersion:   submission.ProblemVersion,
+	}
+	if submission.Result != nil {
+		outcome.OldVerdict = submission.Result.Verdict
+	}
+	return outcome
+}
+
+func rejudgeSubmission(ctx context.Context, deps *CompilationDeps, problems *ProblemCache, submission *repository.Submission, version int) RejudgeOutcome {
+	outcome := newRejudgeOutcome(submission)
+
+	data, err := problems.TestData(ctx, submission.ProblemId, version, submission.RunType)
+	if err != nil {
+		outcome.Err = fmt.Errorf("failed to load problem %d: %w", submission.ProblemId, err)
+		return outcome
+	}
+
+	wrappedCode, err := wrapCode(submission.Language, submission.Code, data)
+	if err != nil {
+		outcome.Err = err
+		return outcome
+	}
+
+	execution, err := deps.Execute(ctx, submission.Language, wrappedCode)
+	if err != nil {
+		outcome.Err = err
+		return outcome
+	}
+
+	verdicts := facade.JudgeTestCaseResults(data.Outputs, execution.Stdout, data.AnswerAnyOrder, data.DeepSort, data.ReturnType)
+	result := newSubmissionResult(execution, verdicts)
+	result.ProblemVersion = data.ProblemVersion
+
+	saved, err := deps.Submissions.SaveRejudge(ctx, submission.SubmissionId, result, submissionStatus(submission.RunType, result))
+	if err != nil {
+		outcome.Err = err
+		return outcome
+	}
+	if !saved {
+		outcome.Err = errors.New("submission is no longer finished")
+		return outcome
+	}
+
+	outcome.NewVersion = result.ProblemVersion
+	outcome.NewVerdict = result.Verdict
+	return outcome
+}
+
+// requeueSubmission hands a submission back to the compilation workers and
+// waits for them to judge it.
+func requeueSubmission(ctx context.Context, deps *CompilationDeps, submission *repository.Submission, options RejudgeOptions) RejudgeOutcome {
+	outcome := newRejudgeOutcome(submission)
+
+	requeued, err := deps.Submissions.Requeue(ctx, submission.SubmissionId, options.Version)
+	if err != nil {
+		outcome.Err = err
+		return outcome
+	}
+	if !requeued {
+		outcome.Err = errors.New("submission is no longer finished")
+		return outcome
+	}
+
+	err = PublishCompilationRequest(ctx, deps.Broker, CompilationRequestMessage{SubmissionId: submission.SubmissionId})
+	if err != nil {
+		outcome.Err = err
+		return outcome
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, options.Wait)
+	defer cancel()
+
+	judged, err := WaitForSubmission(waitCtx, deps.Submissions, submission.SubmissionId)
+	if err != nil {
+		outcome.Err = fmt.Errorf("no result after %v", options.Wait)
+		return outcome
+	}
+
+	outcome.NewVersion = judged.ProblemVersion
+	if judged.Result != nil {
+		outcome.NewVerdict = judged.Result.Verdict
+	}
+	return outcome
+}
+
+// WaitForSubmission polls a submission until it is no longer PENDING or
+// RUNNING, or ctx is done.
+func WaitForSubmission(ctx context.Context, submissions repository.SubmissionRepository, submissionId string) (*repository.Submission, error) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		submission, err := submissions.Get(ctx, submissionId)
+		if err != nil {
+			continue
+		}
+		if submission.Status != "PENDING" && submission.Status != "RUNNING" {
+			return submission, nil
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package workers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+)
+
+// rejudgeProblem adds a and b. Version 1 expected a wrong answer for its
+// second case, which version 2 fixed.
+func rejudgeProblem(version int) *models.Problem {
+	output := 4
+	if version == 1 {
+		output = 5
+	}
+	return &models.Problem{
+		ID:         1,
+		Version:    version,
+		Args:       map[string]string{"a": "int", "b": "int"},
+		ReturnType: "int",
+		JudgeTestCases: []models.TestCase{
+			{Input: bson.M{"a": 1, "b": 2}, Output: 3},
+			{Input: bson.M{"a": 2, "b": 2}, Output: output},
+		},
+	}
+}
+
+func finishedSubmission(id string, verdict string, version int, createdAt time.Time) repository.Submission {
+	status := "SUCCEEDED"
+	if verdict != models.VerdictAccepted {
+		status = "FAILED"
+	}
+	return repository.Submission{
+		SubmissionId:   id,
+		ProblemId:      1,
+		Language:       "python",
+		Code:           "def add(a, b): return a + b",
+		RunType:        "submit",
+		Status:         status,
+		ProblemVersion: version,
+		CreatedAt:      createdAt,
+		Result:         &models.SubmissionResult{Verdict: verdict, ProblemVersion: version},
+	}
+}
+
+// newRejudgeDeps returns deps with version 2 of the problem, a saved revision
+// of version 1, and a correct submission judged wrong against version 1 and
+// one judged right against version 2.
+func newRejudgeDeps(t *testing.T) *CompilationDeps {
+	t.Helper()
+
+	problems := repository.NewMemoryProblemRepository()
+	problems.Put(rejudgeProblem(2))
+	if err := problems.SaveRevision(context.Background(), rejudgeProblem(1)); err != nil {
+		t.Fatalf("SaveRevision: %v", err)
+	}
+
+	submissions := repository.NewMemorySubmissionRepository()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	submissions.Put(finishedSubmission("judged-on-1", models.VerdictWrongAnswer, 1, created))
+	submissions.Put(finishedSubmission("judged-on-2", models.VerdictAccepted, 2, created.Add(time.Hour)))
+
+	return &CompilationDeps{
+		Submissions: submissions,
+		Problems:    problems,
+		Execute: func(ctx context.Context, language string, wrappedCode string) (Execution, error) {
+			return Execution{Stdout: "3\n4\n"}, nil
+		},
+	}
+}
+
+// checkRejudged checks that the outcomes and the stored submissions have the
+// new verdicts and versions, in the order the submissions were created.
+func checkRejudged(t *testing.T, deps *CompilationDeps, outcomes []RejudgeOutcome, want []RejudgeOutcome) {
+	t.Helper()
+
+	if len(outcomes) != len(want) {
+		t.Fatalf("got %d outcomes, want %d: %+v", len(outcomes), len(want), outcomes)
+	}
+	for i, outcome := range outcomes {
+		if outcome.Err != nil {
+			t.Errorf("%s: %v", outcome.SubmissionId, outcome.Err)
+			continue
+		}
+		w := want[i]
+		if outcome.SubmissionId != w.SubmissionId || outcome.OldVerdict != w.OldVerdict || outcome.NewVerdict != w.NewVerdict ||
+			outcome.OldVersion != w.OldVersion || outcome.NewVersion != w.NewVersion {
+			t.Errorf("outcome %d = %+v, want %+v", i, outcome, w)
+		}
+		if outcome.Changed() != (w.OldVerdict != w.NewVerdict) {
+			t.Errorf("%s: Changed = %v", outcome.SubmissionId, outcome.Changed())
+		}
+
+		stored, err := deps.Submissions.Get(context.Background(), outcome.SubmissionId)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if stored.Result == nil || stored.Result.Verdict != w.NewVerdict || stored.ProblemVersion != w.NewVersion {
+			t.Errorf("stored %s = %+v, want %s pinned to version %d", stored.SubmissionId, stored, w.NewVerdict, w.NewVersion)
+		}
+	}
+}
+
+func TestRejudge(t *testing.T) {
+	deps := newRejudgeDeps(t)
+
+	outcomes, err := Rejudge(context.Background(), deps, RejudgeOptions{Filter: repository.SubmissionFilter{ProblemId: 1}, Concurrency: 2})
+	if err != nil {
+		t.Fatalf("Rejudge: %v", err)
+	}
+
+	// The wrong answer was right all along.
+	checkRejudged(t, deps, outcomes, []RejudgeOutcome{
+		{SubmissionId: "judged-on-1", OldVersion: 1, NewVersion: 2, OldVerdict: models.VerdictWrongAnswer, NewVerdict: models.VerdictAccepted},
+		{SubmissionId: "judged-on-2", OldVersion: 2, NewVersion: 2, OldVerdict: models.VerdictAccepted, NewVerdict: models.VerdictAccepted},
+	})
+}
+
+func TestRejudgePinnedVersion(t *testing.T) {
+	deps := newRejudgeDeps(t)
+
+	outcomes, err := Rejudge(context.Background(), deps, RejudgeOptions{Filter: repository.SubmissionFilter{ProblemId: 1}, Version: 1})
+	if err != nil {
+		t.Fatalf("Rejudge: %v", err)
+	}
+
+	checkRejudged(t, deps, outcomes, []RejudgeOutcome{
+		{SubmissionId: "judged-on-1", OldVersion: 1, NewVersion: 1, OldVerdict: models.VerdictWrongAnswer, NewVerdict: models.VerdictWrongAnswer},
+		{SubmissionId: "judged-on-2", OldVersion: 2, NewVersion: 1, OldVerdict: models.VerdictAccepted, NewVerdict: models.VerdictWrongAnswer},
+	})
+}
+
+func TestRejudgeEnqueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deps := newRejudgeDeps(t)
+	b := broker.NewMemoryBroker()
+	t.Cleanup(func() { b.Close() })
+	deps.Broker = b
+
+	if err := StartCompilationWorkers(ctx, deps, 1); err != nil {
+		t.Fatalf("StartCompilationWorkers: %v", err)
+	}
+
+	// The running workers judge the submissions against the pinned version.
+	outcomes, err := Rejudge(ctx, deps, RejudgeOptions{
+		Filter:  repository.SubmissionFilter{ProblemId: 1},
+		Version: 1,
+		Enqueue: true,
+		Wait:    5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Rejudge: %v", err)
+	}
+
+	checkRejudged(t, deps, outcomes, []RejudgeOutcome{
+		{SubmissionId: "judged-on-1", OldVersion: 1, NewVersion: 1, OldVerdict: models.VerdictWrongAnswer, NewVerdict: models.VerdictWrongAnswer},
+		{SubmissionId: "judged-on-2", OldVersion: 2, NewVersion: 1, OldVerdict: models.VerdictAccepted, NewVerdict: models.VerdictWrongAnswer},
+	})
+}
+
+func TestRejudgeFilter(t *testing.T) {
+	deps := newRejudgeDeps(t)
+	submissions := deps.Submissions.(*repository.MemorySubmissionRepository)
+
+	created := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	inRoom := finishedSubmission("in-room", models.VerdictWrongAnswer, 1, created)
+	inRoom.RoomId = "room-1"
+	submissions.Put(inRoom)
+	run := finishedSubmission("run-in-room", models.VerdictAccepted, 1, created)
+	run.RoomId, run.RunType = "room-1", "run"
+	submissions.Put(run)
+	earlier := finishedSubmission("earlier-in-room", models.VerdictWrongAnswer, 1, created.Add(-time.Hour))
+	earlier.RoomId = "room-1"
+	submissions.Put(earlier)
+
+	// Only submits are rejudged unless the filter asks for runs.
+	outcomes, err := Rejudge(context.Background(), deps, RejudgeOptions{
+		Filter: repository.SubmissionFilter{ProblemId: 1, RoomId: "room-1", Since: created},
+	})
+	if err != nil {
+		t.Fatalf("Rejudge: %v", err)
+	}
+	checkRejudged(t, deps, outcomes, []RejudgeOutcome{
+		{SubmissionId: "in-room", OldVersion: 1, NewVersion: 2, OldVerdict: models.VerdictWrongAnswer, NewVerdict: models.VerdictAccepted},
+	})
+
+	for _, id := range []string{"run-in-room", "earlier-in-room", "judged-on-1"} {
+		stored, _ := submissions.Get(context.Background(), id)
+		if stored.ProblemVersion != 1 {
+			t.Errorf("%s was rejudged, want it left out by the filter", id)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+	"octree.io-worker/internal/utils"
+)
+
+// submissionLease is held by a worker while it executes a submission. Only
+// the lease owner may write the final result.
+type submissionLease struct {
+	submissions  repository.SubmissionRepository
+	submissionId string
+	owner        string
+	duration     time.Duration
+	stop         context.CancelFunc
+	done         chan struct{}
+}
+
+func leaseOwner(workerId int) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), workerId)
+}
+
+func leaseDuration() time.Duration {
+	return utils.GetEnvDuration("SUBMISSION_LEASE_DURATION", 60*time.Second)
+}
+
+// claimSubmission atomically moves a submission from PENDING to RUNNING, or
+// takes over a RUNNING submission whose lease expired because its worker died.
+// It returns ErrSubmissionLeaseHeld right away while another worker holds the
+// lease.
+func claimSubmission(ctx context.Context, submissions repository.SubmissionRepository, submissionId string, owner string) (*repository.Submission, *submissionLease, error) {
+	duration := leaseDuration()
+
+	submission, err := submissions.Claim(ctx, submissionId, owner, duration)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	heartbeatCtx, stop := context.WithCancel(context.Background())
+	lease := &submissionLease{
+		submissions:  submissions,
+		submissionId: submissionId,
+		owner:        owner,
+		duration:     duration,
+		stop:         stop,
+		done:         make(chan struct{}),
+	}
+	go lease.heartbeat(heartbeatCtx)
+
+	return submission, lease, nil
+}
+
+// leaseRetryDelay is how long to wait before a message for a submission whose
+// lease is held is delivered again: until the lease expires, when a worker
+// that died can be taken over from.
+func leaseRetryDelay(ctx context.Context, submissions repository.SubmissionRepository, submissionId string) time.Duration {
+	expiresAt, err := submissions.LeaseExpiry(ctx, submissionId)
+	if err != nil {
+		log.Printf("Failed to get lease expiry of submission %s: %v", submissionId, err)
+		return leaseDuration()
+	}
+	if expiresAt == nil {
+		return time.Second
+	}
+	return max(time.Until(*expiresAt), 0) + time.Second
+}
+
+func (l *submissionLease) heartbeat(ctx context.Context) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.duration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			owned, err := l.submissions.ExtendLease(ctx, l.submissionId, l.owner, l.duration)
+			if err != nil {
+				log.Printf("Failed to extend lease on submission %s: %v", l.submissionId, err)
+			} else if !owned {
+				log.Printf("Lost lease on submission %s", l.submissionId)
+				return
+			}
+		}
+	}
+}
+
+// Complete stops the heartbeat and writes the final result. It reports false
+// when the lease was taken over by another worker, in which case that worker
+// owns the result and nothing was written.
+func (l *submissionLease) Complete(ctx context.Context, result *models.SubmissionResult, status string) (bool, error) {
+	l.stop()
+	<-l.done
+
+	return l.submissions.Complete(ctx, l.submissionId, l.owner, result, status)
+}
@@ -0,0 +1,187 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"octree.io-worker/internal/models"
+)
+
+// triviaBatchMetrics are published on /debug/vars under "triviaBatches".
+var triviaBatchMetrics = expvar.NewMap("triviaBatches")
+
+type triviaBatchResult struct {
+	grade models.TriviaGrade
+	err   error
+}
+
+type triviaBatchItem struct {
+	ctx     context.Context
+	request TriviaGradingRequest
+	result  chan triviaBatchResult
+}
+
+// triviaBatcher collects the answers all trivia workers grade over a short
+// window and grades them with one request, up to size answers at a time.
+type triviaBatcher struct {
+	grader BatchGrader
+	size   int
+	window time.Duration
+	items  chan *triviaBatchItem
+}
+
+func newTriviaBatcher(grader BatchGrader, size int, window time.Duration) *triviaBatcher {
+	return &triviaBatcher{
+		grader: grader,
+		size:   size,
+		window: window,
+		items:  make(chan *triviaBatchItem),
+	}
+}
+
+// run collects batches until ctx is done. A batch is sent when it is full or
+// the window since its first answer has passed.
+func (b *triviaBatcher) run(ctx context.Context) {
+	for {
+		var batch []*triviaBatchItem
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-b.items:
+			batch = append(batch, item)
+		}
+
+		timer := time.NewTimer(b.window)
+	collect:
+		for len(batch) < b.size {
+			select {
+			case <-ctx.Done():
+				break collect
+			case <-timer.C:
+				break collect
+			case item := <-b.items:
+				batch = append(batch, item)
+			}
+		}
+		timer.Stop()
+
+		go b.flush(ctx, batch)
+	}
+}
+
+// batchContext returns a context that is done once the callers of every
+// item stopped waiting, or ctx is done.
+func batchContext(ctx context.Context, items []*triviaBatchItem) (context.Context, context.CancelFunc) {
+	batchCtx, cancel := context.WithCancel(ctx)
+
+	var waiting atomic.Int32
+	waiting.Store(int32(len(items)))
+	stops := make([]func() bool, len(items))
+	for i, item := range items {
+		stops[i] = context.AfterFunc(item.ctx, func() {
+			if waiting.Add(-1) == 0 {
+				cancel()
+			}
+		})
+	}
+
+	return batchCtx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancel()
+	}
+}
+
+// flush grades a batch and hands each answer its grade. Answers whose caller
+// stopped waiting are left out, a batch is graded while any caller still
+// waits and a single answer while its caller does, and a batch the model
+// keeps answering with malformed output is graded one answer at a time.
+func (b *triviaBatcher) flush(ctx context.Context, batch []*triviaBatchItem) {
+	pending := batch[:0]
+	for _, item := range batch {
+		if item.ctx.Err() == nil {
+			pending = append(pending, item)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	triviaBatchMetrics.Add("batches", 1)
+	triviaBatchMetrics.Add("answers", int64(len(pending)))
+
+	if len(pending) == 1 {
+		grade, err := b.grade(ctx, pending[0])
+		pending[0].result <- triviaBatchResult{grade, err}
+		return
+	}
+
+	requests := make([]TriviaGradingRequest, len(pending))
+	for i, item := range pending {
+		requests[i] = item.request
+	}
+
+	batchCtx, cancel := batchContext(ctx, pending)
+	grades, err := b.grader.GradeBatch(batchCtx, requests)
+	cancel()
+	if err == nil {
+		for i, item := range pending {
+			item.result <- triviaBatchResult{grade: grades[i]}
+		}
+		return
+	}
+
+	if !errors.Is(err, errMalformedGrade) {
+		for _, item := range pending {
+			item.result <- triviaBatchResult{err: err}
+		}
+		return
+	}
+
+	log.Printf("Batch of %d answers couldn't be graded, grading them one at a time: %v", len(pending), err)
+	triviaBatchMetrics.Add("split", 1)
+
+	var wg sync.WaitGroup
+	for _, item := range pending {
+		wg.Add(1)
+		go func(item *triviaBatchItem) {
+			defer wg.Done()
+			grade, err := b.grade(ctx, item)
+			item.result <- triviaBatchResult{grade, err}
+		}(item)
+	}
+	wg.Wait()
+}
+
+// grade grades one answer until its caller stops waiting or ctx is done.
+func (b *triviaBatcher) grade(ctx context.Context, item *triviaBatchItem) (models.TriviaGrade, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer context.AfterFunc(item.ctx, cancel)()
+	defer cancel()
+
+	return b.grader.Grade(ctx, item.request)
+}
+
+// Grade adds an answer to the next batch and waits for its grade.
+func (b *triviaBatcher) Grade(ctx context.Context, request TriviaGradingRequest) (models.TriviaGrade, error) {
+	item := &triviaBatchItem{ctx: ctx, request: request, result: make(chan triviaBatchResult, 1)}
+
+	select {
+	case b.items <- item:
+	case <-ctx.Done():
+		return models.TriviaGrade{}, ctx.Err()
+	}
+
+	select {
+	case result := <-item.result:
+		return result.grade, result.err
+	case <-ctx.Done():
+		return models.TriviaGrade{}, ctx.Err()
+	}
+}
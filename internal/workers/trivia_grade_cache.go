@@ -0,0 +1,132 @@
+package workers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+)
+
+// triviaGradeCacheMetrics are published on /debug/vars under
+// "triviaGradeCache".
+var triviaGradeCacheMetrics = expvar.NewMap("triviaGradeCache")
+
+func init() {
+	triviaGradeCacheMetrics.Set("hitRate", expvar.Func(func() any {
+		hits := metricValue(triviaGradeCacheMetrics, "hits") + metricValue(triviaGradeCacheMetrics, "similarHits")
+		lookups := hits + metricValue(triviaGradeCacheMetrics, "misses")
+		if lookups == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(lookups)
+	}))
+}
+
+func metricValue(metrics *expvar.Map, key string) int64 {
+	if value, ok := metrics.Get(key).(*expvar.Int); ok {
+		return value.Value()
+	}
+	return 0
+}
+
+// normalizeAnswer lowercases an answer and reduces it to its words, so
+// answers differing in case, punctuation or spacing share a cache key.
+func normalizeAnswer(answer string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(answer), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func triviaGradeKey(request TriviaGradingRequest, model string) repository.TriviaGradeKey {
+	key := repository.TriviaGradeKey{
+		QuestionId:    request.Question.ID,
+		PromptVersion: request.PromptVersion,
+		PromptHash:    request.PromptHash,
+		Model:         model,
+		AnswerHash:    fmt.Sprintf("%x", sha256.Sum256([]byte(normalizeAnswer(request.Answer)))),
+	}
+	if request.Question.Rubric != nil {
+		key.RubricVersion = request.Question.Rubric.Version
+	}
+	return key
+}
+
+// cachedGrade returns the cached grade of an identical answer, or of a
+// similar one when minSimilarity is set and the grader can embed answers. On
+// a miss it also returns the answer's embedding, if it was computed, to
+// cache the answer's grade with.
+func cachedGrade(ctx context.Context, deps *TriviaDeps, key repository.TriviaGradeKey, answer string) (*models.TriviaGrade, []float32) {
+	grade, err := deps.GradeCache.Get(ctx, key)
+	if err == nil {
+		triviaGradeCacheMetrics.Add("hits", 1)
+		return grade, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Failed to look up cached grade for question %d: %v", key.QuestionId, err)
+	}
+
+	embedder, ok := deps.Grader.(Embedder)
+	if deps.minSimilarity <= 0 || !ok {
+		triviaGradeCacheMetrics.Add("misses", 1)
+		return nil, nil
+	}
+
+	embedding, err := embedder.Embed(ctx, normalizeAnswer(answer))
+	if err != nil {
+		log.Printf("Failed to embed answer to question %d: %v", key.QuestionId, err)
+		triviaGradeCacheMetrics.Add("misses", 1)
+		return nil, nil
+	}
+
+	grade, err = deps.GradeCache.Similar(ctx, key, embedding, deps.minSimilarity)
+	if err == nil {
+		triviaGradeCacheMetrics.Add("similarHits", 1)
+
+		// Cache the answer itself too, so it hits without an embedding next
+		// time.
+		if err := deps.GradeCache.Put(ctx, key, grade, embedding); err != nil {
+			log.Printf("Failed to cache grade for question %d: %v", key.QuestionId, err)
+		}
+		return grade, embedding
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Failed to look up similar grades for question %d: %v", key.QuestionId, err)
+	}
+
+	triviaGradeCacheMetrics.Add("misses", 1)
+	return nil, embedding
+}
+
+// gradeAnswer grades an answer, reusing the cached grade of an identical or
+// similar answer when there is one.
+func gradeAnswer(ctx context.Context, deps *TriviaDeps, request TriviaGradingRequest) (models.TriviaGrade, error) {
+	if deps.GradeCache == nil {
+		return deps.grade(ctx, request)
+	}
+
+	key := triviaGradeKey(request, deps.model)
+	cached, embedding := cachedGrade(ctx, deps, key, request.Answer)
+	if cached != nil {
+		grade := *cached
+		grade.Answer = request.Answer
+		grade.Cached = true
+		return grade, nil
+	}
+
+	grade, err := deps.grade(ctx, request)
+	if err != nil {
+		return grade, err
+	}
+
+	if err := deps.GradeCache.Put(ctx, key, &grade, embedding); err != nil {
+		log.Printf("Failed to cache grade for question %d: %v", key.QuestionId, err)
+	}
+	return grade, nil
+}
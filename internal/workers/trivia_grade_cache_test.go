@@ -0,0 +1,117 @@
+package workers
+
+import (
+	"context"
+	"testing"
+
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+)
+
+// embeddingGrader counts the answers it grades and embeds normalized answers
+// with embeddings.
+type embeddingGrader struct {
+	FakeGrader
+	graded     *int
+	embeddings map[string][]float32
+}
+
+func (g embeddingGrader) Grade(ctx context.Context, request TriviaGradingRequest) (models.TriviaGrade, error) {
+	*g.graded++
+	return g.FakeGrader.Grade(ctx, request)
+}
+
+func (g embeddingGrader) Embed(ctx context.Context, text string) ([]float32, error) {
+	return g.embeddings[text], nil
+}
+
+func newCachedGradeDeps(minSimilarity float64) (*TriviaDeps, *int) {
+	graded := new(int)
+	grader := embeddingGrader{
+		graded: graded,
+		embeddings: map[string][]float32{
+			"tcp":                    {1, 0},
+			"the tcp protocol":       {0.99, 0.1},
+			"user datagram protocol": {0, 1},
+		},
+	}
+	return &TriviaDeps{
+		GradeCache:    repository.NewMemoryTriviaGradeCache(),
+		Grader:        grader,
+		model:         grader.Model(),
+		minSimilarity: minSimilarity,
+	}, graded
+}
+
+func TestGradeAnswerCache(t *testing.T) {
+	ctx := context.Background()
+	deps, graded := newCachedGradeDeps(0)
+	question := &models.TriviaQuestion{ID: 1, Answer: "Transmission Control Protocol"}
+	request := func(answer string, promptHash string) TriviaGradingRequest {
+		return TriviaGradingRequest{Question: question, Answer: answer, PromptHash: promptHash}
+	}
+
+	first, err := gradeAnswer(ctx, deps, request("Transmission control protocol", "v1"))
+	if err != nil || first.Cached {
+		t.Fatalf("gradeAnswer = %+v, %v, want a fresh grade", first, err)
+	}
+
+	// Answers differing in case and punctuation share the grade.
+	hits := metricValue(triviaGradeCacheMetrics, "hits")
+	second, err := gradeAnswer(ctx, deps, request("transmission, control protocol!", "v1"))
+	if err != nil || !second.Cached || second.Score != first.Score || second.Answer != "transmission, control protocol!" {
+		t.Errorf("gradeAnswer = %+v, %v, want the cached grade with the answer given", second, err)
+	}
+	if *graded != 1 || metricValue(triviaGradeCacheMetrics, "hits") != hits+1 {
+		t.Errorf("graded %d answers, want the second one from the cache", *graded)
+	}
+
+	// Grades aren't reused with another template or model.
+	if grade, _ := gradeAnswer(ctx, deps, request("Transmission control protocol", "v2")); grade.Cached {
+		t.Error("the grade was reused with another prompt template")
+	}
+	deps.model = "another model"
+	if grade, _ := gradeAnswer(ctx, deps, request("Transmission control protocol", "v1")); grade.Cached {
+		t.Error("the grade was reused with another model")
+	}
+	if *graded != 3 {
+		t.Errorf("graded %d answers, want 3", *graded)
+	}
+}
+
+func TestGradeAnswerSimilar(t *testing.T) {
+	ctx := context.Background()
+	deps, graded := newCachedGradeDeps(0.9)
+	question := &models.TriviaQuestion{ID: 1, Answer: "Transmission Control Protocol"}
+	request := func(answer string) TriviaGradingRequest {
+		return TriviaGradingRequest{Question: question, Answer: answer}
+	}
+
+	first, err := gradeAnswer(ctx, deps, request("TCP"))
+	if err != nil {
+		t.Fatalf("gradeAnswer: %v", err)
+	}
+
+	similarHits := metricValue(triviaGradeCacheMetrics, "similarHits")
+	similar, err := gradeAnswer(ctx, deps, request("The TCP protocol"))
+	if err != nil || !similar.Cached || similar.Score != first.Score {
+		t.Errorf("gradeAnswer of a similar answer = %+v, %v, want the cached grade", similar, err)
+	}
+	if metricValue(triviaGradeCacheMetrics, "similarHits") != similarHits+1 {
+		t.Error("the similar answer wasn't counted as a similar hit")
+	}
+
+	// The similar answer is cached as well, so it hits without an
+	// embedding next time.
+	delete(deps.Grader.(embeddingGrader).embeddings, "the tcp protocol")
+	if again, _ := gradeAnswer(ctx, deps, request("the TCP protocol")); !again.Cached {
+		t.Error("the similar answer wasn't cached")
+	}
+
+	if different, _ := gradeAnswer(ctx, deps, request("User Datagram Protocol")); different.Cached {
+		t.Error("a different answer got a cached grade")
+	}
+	if *graded != 2 {
+		t.Errorf("graded %d answers, want 2", *graded)
+	}
+}
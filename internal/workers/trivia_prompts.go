@@ -0,0 +1,150 @@
+package workers
+
+import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+)
+
+const triviaGradingTemplateName = "trivia_grading"
+
+// defaultTriviaGradingTemplate is used while prompt_templates has no
+// trivia_grading template. Grades made with it have prompt version 0.
+//
+//go:embed prompts/trivia_grading.tmpl
+var defaultTriviaGradingTemplate string
+
+// triviaPromptData is what grading prompt templates are rendered with.
+// Questions without a rubric get an empty one with standard strictness.
+// Answer is quoted with quoteAnswer.
+type triviaPromptData struct {
+	Question        string
+	ReferenceAnswer string
+	Answer          string
+	Rubric          models.TriviaRubric
+}
+
+type cachedPrompt struct {
+	version  int
+	hash     string
+	template *template.Template
+	loadedAt time.Time
+}
+
+// TriviaPrompts renders grading prompts from the templates in the
+// repository, reloading them after ttl so edited templates apply without a
+// restart.
+type TriviaPrompts struct {
+	templates repository.PromptTemplateRepository
+	ttl       time.Duration
+
+	mu     sync.Mutex
+	cached map[int]cachedPrompt
+}
+
+func NewTriviaPrompts(templates repository.PromptTemplateRepository, ttl time.Duration) *TriviaPrompts {
+	return &TriviaPrompts{templates: templates, ttl: ttl, cached: make(map[int]cachedPrompt)}
+}
+
+func parsePromptTemplate(version int, text string) (*template.Template, error) {
+	parsed, err := template.New(fmt.Sprintf("%s-v%d", triviaGradingTemplateName, version)).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template %s v%d: %w", triviaGradingTemplateName, version, err)
+	}
+	return parsed, nil
+}
+
+// load returns the template of version, 0 being the latest one.
+func (p *TriviaPrompts) load(ctx context.Context, version int) (cachedPrompt, error) {
+	p.mu.Lock()
+	cached, ok := p.cached[version]
+	p.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < p.ttl {
+		return cached, nil
+	}
+
+	var stored *models.PromptTemplate
+	var err error
+	if p.templates != nil {
+		stored, err = p.templates.GetPromptTemplate(ctx, triviaGradingTemplateName, version)
+	}
+
+	switch {
+	case p.templates == nil || (errors.Is(err, repository.ErrNotFound) && version == 0):
+		stored = &models.PromptTemplate{Name: triviaGradingTemplateName, Template: defaultTriviaGradingTemplate}
+	case err != nil && ok:
+		log.Printf("Failed to reload prompt template %s v%d, using the cached one: %v", triviaGradingTemplateName, version, err)
+		return cached, nil
+	case err != nil:
+		return cached, fmt.Errorf("failed to load prompt template %s v%d: %w", triviaGradingTemplateName, version, err)
+	}
+
+	parsed, err := parsePromptTemplate(stored.Version, stored.Template)
+	if err != nil {
+		return cached, err
+	}
+
+	cached = cachedPrompt{version: stored.Version, hash: promptHash(stored.Template), template: parsed, loadedAt: time.Now()}
+	p.mu.Lock()
+	p.cached[version] = cached
+	p.mu.Unlock()
+
+	return cached, nil
+}
+
+// promptHash identifies the text of a grading template together with the
+// system prompt it is sent after.
+func promptHash(text string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(triviaGradingSystemPrompt+"\x00"+text)))
+}
+
+// quoteAnswer encloses an answer in <answer> tags, escaping it so it can't
+// close them or open others, so the model can tell it from the instructions.
+func quoteAnswer(answer string) string {
+	return "<answer>" + html.EscapeString(answer) + "</answer>"
+}
+
+// Render returns the request to grade an answer with, with the prompt
+// rendered from the question's template.
+func (p *TriviaPrompts) Render(ctx context.Context, question *models.TriviaQuestion, answer string) (TriviaGradingRequest, error) {
+	request := TriviaGradingRequest{Question: question, Answer: answer}
+
+	prompt, err := p.load(ctx, question.PromptVersion)
+	if err != nil {
+		return request, err
+	}
+
+	data := triviaPromptData{
+		Question:        question.Question,
+		ReferenceAnswer: question.Answer,
+		Answer:          quoteAnswer(answer),
+	}
+	if question.Rubric != nil {
+		data.Rubric = *question.Rubric
+	}
+	if data.Rubric.Strictness == "" {
+		data.Rubric.Strictness = models.StrictnessStandard
+	}
+
+	var rendered strings.Builder
+	err = prompt.template.Execute(&rendered, data)
+	if err != nil {
+		return request, fmt.Errorf("failed to render prompt template %s v%d: %w", triviaGradingTemplateName, prompt.version, err)
+	}
+
+	request.Prompt = rendered.String()
+	request.PromptVersion = prompt.version
+	request.PromptHash = prompt.hash
+	return request, nil
+}
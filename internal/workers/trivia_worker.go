@@ -2,51 +2,261 @@ package workers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"sync"
 	"time"
 
-	ampq "github.com/rabbitmq/amqp091-go"
-	openai "github.com/sashabaranov/go-openai"
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+	"octree.io-worker/internal/utils"
 )
 
-func processTriviaGrading() {
-	prompt := `I want you to grade these answers for these questions. For each question, put either a Yes or No for whether or not it passes an interview or an exam. Explain in-depth what the right answer is supposed to be. Be strict about the grading to make sure that the explanations are correct. It is acceptable if there are no specific examples unless the question specifically asks for examples. Q: What is a thread? A: A thread is another instance of a program running within the same program.`
-
-	start := time.Now()
-
-	client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT4oMini,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-		},
-	)
+const TriviaResponsesQueue = "trivia_responses"
 
+// TriviaSubmissionMessage holds a user's answers, Answers[i] answering the
+// question QuestionIds[i].
+type TriviaSubmissionMessage struct {
+	SubmissionId string   `json:"submissionId"`
+	SocketId     string   `json:"socketId"`
+	RoomId       string   `json:"roomId"`
+	Username     string   `json:"username"`
+	QuestionIds  []int    `json:"questionIds"`
+	Answers      []string `json:"answers"`
+}
+
+// TriviaResponseMessage is the graded submission, published with the
+// FINISHED event. Score sums the grades' scores, out of 100 per question. The
+// RUNNING event, published when grading starts, has a RUNNING status and no
+// grades.
+type TriviaResponseMessage struct {
+	SubmissionId string               `json:"submissionId"`
+	SocketId     string               `json:"socketId"`
+	RoomId       string               `json:"roomId"`
+	Username     string               `json:"username"`
+	Status       string               `json:"status"`
+	Score        int                  `json:"score"`
+	Passed       int                  `json:"passed"`
+	Questions    int                  `json:"questions"`
+	Grades       []models.TriviaGrade `json:"grades"`
+	Error        string               `json:"error,omitempty"`
+	Event        string               `json:"event"`
+}
+
+// TriviaDeps are the services the trivia pipeline depends on. Grader
+// defaults to the one LoadLLMConfig selects, without PromptTemplates answers
+// are graded with the built-in prompt, and without GradeCache every answer is
+// graded.
+type TriviaDeps struct {
+	Broker          broker.Broker
+	Questions       repository.TriviaQuestionRepository
+	Submissions     repository.TriviaSubmissionRepository
+	PromptTemplates repository.PromptTemplateRepository
+	GradeCache      repository.TriviaGradeCache
+	Grader          LLMGrader
+
+	prompts       *TriviaPrompts
+	model         string
+	minSimilarity float64
+	batcher       *triviaBatcher
+}
+
+// grade grades an answer with the batcher when batching is enabled.
+func (deps *TriviaDeps) grade(ctx context.Context, request TriviaGradingRequest) (models.TriviaGrade, error) {
+	if deps.batcher != nil {
+		return deps.batcher.Grade(ctx, request)
+	}
+	return deps.Grader.Grade(ctx, request)
+}
+
+func triviaProgressEventsEnabled() bool {
+	return utils.GetEnvBool("TRIVIA_PROGRESS_EVENTS", true)
+}
+
+func parseTriviaSubmission(body []byte) (TriviaSubmissionMessage, error) {
+	var message TriviaSubmissionMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return message, err
+	}
+
+	if message.SubmissionId == "" {
+		return message, errors.New("submissionId is missing or empty")
+	}
+	if len(message.QuestionIds) == 0 || len(message.QuestionIds) != len(message.Answers) {
+		return message, errors.New("questionIds and answers must be non-empty and of the same length")
+	}
+
+	return message, nil
+}
+
+// gradeTriviaSubmission grades the answers of a submission concurrently, so
+// they can share batches.
+func gradeTriviaSubmission(ctx context.Context, deps *TriviaDeps, message TriviaSubmissionMessage) ([]models.TriviaGrade, error) {
+	questions, err := deps.Questions.GetByIDs(ctx, message.QuestionIds)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	grades := make([]models.TriviaGrade, len(message.Answers))
+	errs := make([]error, len(message.Answers))
+
+	var wg sync.WaitGroup
+	for i, answer := range message.Answers {
+		wg.Add(1)
+		go func(i int, question *models.TriviaQuestion, answer string) {
+			defer wg.Done()
+
+			request, err := deps.prompts.Render(ctx, question, answer)
+			if err == nil {
+				grades[i], err = gradeAnswer(ctx, deps, request)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to grade question %d: %w", question.ID, err)
+				cancel()
+			}
+		}(i, questions[message.QuestionIds[i]], answer)
+	}
+	wg.Wait()
+
+	// Report the error that cancelled the others rather than a cancellation.
+	var gradeErr error
+	for _, err := range errs {
+		if err != nil && (gradeErr == nil || errors.Is(gradeErr, context.Canceled)) {
+			gradeErr = err
+		}
+	}
+	if gradeErr != nil {
+		return nil, gradeErr
+	}
+
+	return grades, nil
+}
+
+func newTriviaSubmission(message TriviaSubmissionMessage, grades []models.TriviaGrade) *repository.TriviaSubmission {
+	submission := &repository.TriviaSubmission{
+		SubmissionId: message.SubmissionId,
+		RoomId:       message.RoomId,
+		Username:     message.Username,
+		Status:       "SUCCEEDED",
+		Grades:       grades,
+		Questions:    len(message.QuestionIds),
+		GradedAt:     time.Now(),
+	}
+	for _, grade := range grades {
+		submission.Score += grade.Score
+		if grade.Pass {
+			submission.Passed++
+		}
+	}
+	return submission
+}
+
+// sendTriviaResponseMessage publishes the response to the request's ReplyTo
+// queue if it has one, and to trivia_responses otherwise.
+func sendTriviaResponseMessage(b broker.Broker, delivery broker.Message, response TriviaResponseMessage) error {
+	if delivery.ReplyTo != "" {
+		return publishResponse(b, delivery.ReplyTo, delivery.CorrelationId, response)
+	}
+	return publishResponse(b, TriviaResponsesQueue, "", response)
+}
+
+// processTriviaSubmission grades a submission and publishes the response. It
+// returns true instead when the LLM provider is unavailable and the message
+// should be requeued, which happens once per message.
+func processTriviaSubmission(deps *TriviaDeps, msg broker.Delivery) bool {
+	delivery := msg.Message()
+
+	message, err := parseTriviaSubmission(delivery.Body)
 	if err != nil {
-		fmt.Printf("ChatCompletion error: %v\n", err)
-		return
+		log.Printf("Invalid trivia submission: %v\n", err)
+		return false
 	}
 
-	fmt.Println(resp.Choices[0].Message.Content)
+	ctx, cancel := context.WithTimeout(context.Background(), utils.GetEnvDuration("TRIVIA_GRADING_TIMEOUT", 5*time.Minute))
+	defer cancel()
 
-	fmt.Printf("Response took %v to complete", time.Since(start))
+	if message.SocketId != "" && triviaProgressEventsEnabled() {
+		event := TriviaResponseMessage{
+			SubmissionId: message.SubmissionId,
+			SocketId:     message.SocketId,
+			RoomId:       message.RoomId,
+			Username:     message.Username,
+			Status:       "RUNNING",
+			Questions:    len(message.QuestionIds),
+			Event:        EventRunning,
+		}
+		if err := publishResponse(deps.Broker, TriviaResponsesQueue, "", event); err != nil {
+			log.Printf("Failed to send RUNNING event for trivia submission %s: %v", message.SubmissionId, err)
+		}
+	}
+
+	submission := newTriviaSubmission(message, nil)
+	grades, err := gradeTriviaSubmission(ctx, deps, message)
+	if errors.Is(err, ErrLLMUnavailable) && !msg.Redelivered() {
+		log.Printf("Requeueing trivia submission %s: %v\n", message.SubmissionId, err)
+		return true
+	}
+	if err != nil {
+		log.Printf("Failed to grade trivia submission %s: %v\n", message.SubmissionId, err)
+		submission.Status = "FAILED"
+		submission.Error = err.Error()
+	} else {
+		submission = newTriviaSubmission(message, grades)
+	}
+
+	response := TriviaResponseMessage{
+		SubmissionId: message.SubmissionId,
+		SocketId:     message.SocketId,
+		RoomId:       message.RoomId,
+		Username:     message.Username,
+		Status:       submission.Status,
+		Score:        submission.Score,
+		Passed:       submission.Passed,
+		Questions:    submission.Questions,
+		Grades:       submission.Grades,
+		Event:        EventFinished,
+	}
+	if submission.Status == "FAILED" {
+		response.Error = "grading failed"
+	}
+
+	if err := deps.Submissions.Save(ctx, submission); err != nil {
+		log.Printf("Failed to save trivia submission %s: %v\n", message.SubmissionId, err)
+		if submission.Status != "FAILED" {
+			response.Status = "FAILED"
+			response.Score, response.Passed, response.Grades = 0, 0, nil
+			response.Error = "failed to save grades"
+		}
+	}
+
+	if err := sendTriviaResponseMessage(deps.Broker, delivery, response); err != nil {
+		log.Printf("Failed to send a trivia response message: %v", err)
+	}
+	return false
 }
 
-func SpawnTriviaWorker(id int, msgs <-chan ampq.Delivery) {
+func SpawnTriviaWorker(id int, deps *TriviaDeps, msgs <-chan broker.Delivery) {
 	for msg := range msgs {
-		log.Printf("[Trivia Worker %d] Received message: %s", id, msg.Body)
+		log.Printf("[Trivia Worker %d] Received message: %s", id, msg.Message().Body)
 
-		processTriviaGrading()
+		if processTriviaSubmission(deps, msg) {
+			// Give the provider time to recover before the message comes back,
+			// without holding up the worker.
+			time.AfterFunc(utils.GetEnvDuration("TRIVIA_REQUEUE_DELAY", 30*time.Second), func() {
+				if err := msg.Nack(true); err != nil {
+					log.Printf("[Trivia Worker %d] Failed to nack message: %v", id, err)
+				}
+			})
+			continue
+		}
 
-		if err := msg.Ack(false); err != nil {
+		if err := msg.Ack(); err != nil {
 			log.Printf("[Trivia Worker %d] Failed to ack message: %v", id, err)
 		} else {
 			log.Printf("[Trivia Worker %d] Message ack'd", id)
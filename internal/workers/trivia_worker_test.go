@@ -0,0 +1,312 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/models"
+	"octree.io-worker/internal/repository"
+)
+
+type triviaPipeline struct {
+	broker      *broker.MemoryBroker
+	submissions *repository.MemoryTriviaSubmissionRepository
+	responses   <-chan broker.Delivery
+}
+
+// startTriviaPipeline runs count trivia workers with grader against the
+// in-memory broker and repositories.
+func startTriviaPipeline(t *testing.T, grader LLMGrader, count int) *triviaPipeline {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	b := broker.NewMemoryBroker()
+	t.Cleanup(func() { b.Close() })
+
+	questions := repository.NewMemoryTriviaQuestionRepository()
+	questions.Put(&models.TriviaQuestion{ID: 1, Question: "What does TCP stand for?", Answer: "Transmission Control Protocol"})
+	questions.Put(&models.TriviaQuestion{ID: 2, Question: "What is a hash table?", Answer: "A hash table maps keys to values"})
+
+	pipeline := &triviaPipeline{broker: b, submissions: repository.NewMemoryTriviaSubmissionRepository()}
+
+	deps := &TriviaDeps{
+		Broker:      b,
+		Questions:   questions,
+		Submissions: pipeline.submissions,
+		Grader:      grader,
+	}
+	if err := StartTriviaWorkers(ctx, deps, count); err != nil {
+		t.Fatalf("StartTriviaWorkers: %v", err)
+	}
+	if _, ok := grader.(BatchGrader); ok && deps.batcher == nil {
+		t.Fatal("the batch grader isn't batched")
+	}
+
+	responses, err := b.Consume(ctx, TriviaResponsesQueue, broker.ConsumeOptions{})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	pipeline.responses = responses
+
+	return pipeline
+}
+
+func (p *triviaPipeline) submit(t *testing.T, message TriviaSubmissionMessage) {
+	t.Helper()
+
+	body, _ := json.Marshal(message)
+	if err := p.broker.Publish(context.Background(), triviaSubmissionsQueue, broker.Message{Body: body}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+// next returns the next response on trivia_responses.
+func (p *triviaPipeline) next(t *testing.T) TriviaResponseMessage {
+	t.Helper()
+
+	select {
+	case msg := <-p.responses:
+		msg.Ack()
+		var response TriviaResponseMessage
+		if err := json.Unmarshal(msg.Message().Body, &response); err != nil {
+			t.Fatalf("invalid response %s: %v", msg.Message().Body, err)
+		}
+		return response
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a response")
+		return TriviaResponseMessage{}
+	}
+}
+
+// finished returns the next FINISHED response on trivia_responses.
+func (p *triviaPipeline) finished(t *testing.T) TriviaResponseMessage {
+	t.Helper()
+
+	for {
+		if response := p.next(t); response.Event == EventFinished {
+			return response
+		}
+	}
+}
+
+func TestTriviaPipeline(t *testing.T) {
+	pipeline := startTriviaPipeline(t, FakeGrader{}, 1)
+
+	pipeline.submit(t, TriviaSubmissionMessage{
+		SubmissionId: "trivia-1",
+		SocketId:     "socket-1",
+		Username:     "alice",
+		QuestionIds:  []int{1, 2},
+		Answers:      []string{"Transmission control protocol", "It stores things"},
+	})
+
+	running := pipeline.next(t)
+	if running.Event != EventRunning || running.Status != "RUNNING" || running.Questions != 2 {
+		t.Errorf("first event = %+v, want RUNNING with 2 questions", running)
+	}
+	response := pipeline.finished(t)
+
+	if response.SubmissionId != "trivia-1" || response.Status != "SUCCEEDED" {
+		t.Fatalf("FINISHED = %+v, want trivia-1 SUCCEEDED", response)
+	}
+	if response.Questions != 2 || response.Passed != 1 || response.Score != 100 {
+		t.Errorf("FINISHED = %+v, want 1 of 2 passed with a score of 100", response)
+	}
+	if len(response.Grades) != 2 || !response.Grades[0].Pass || response.Grades[1].Pass {
+		t.Fatalf("grades = %+v, want the first answer passed and the second failed", response.Grades)
+	}
+	if response.Grades[0].QuestionId != 1 || response.Grades[1].QuestionId != 2 {
+		t.Errorf("grades = %+v, want them in the order of the questions", response.Grades)
+	}
+
+	stored, err := pipeline.submissions.Get(context.Background(), "trivia-1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Status != "SUCCEEDED" || stored.Score != 100 || stored.Passed != 1 || len(stored.Grades) != 2 {
+		t.Errorf("stored submission = %+v, want the graded submission", stored)
+	}
+}
+
+func TestTriviaPipelineUnknownQuestion(t *testing.T) {
+	pipeline := startTriviaPipeline(t, FakeGrader{}, 1)
+
+	pipeline.submit(t, TriviaSubmissionMessage{
+		SubmissionId: "trivia-2",
+		QuestionIds:  []int{1, 42},
+		Answers:      []string{"Transmission control protocol", "Anything"},
+	})
+	response := pipeline.finished(t)
+
+	if response.Status != "FAILED" || response.Grades != nil {
+		t.Errorf("FINISHED = %+v, want FAILED without grades", response)
+	}
+
+	stored, err := pipeline.submissions.Get(context.Background(), "trivia-2")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Status != "FAILED" {
+		t.Errorf("stored status = %q, want FAILED", stored.Status)
+	}
+}
+
+// unavailableGrader can't grade answers to question 2.
+type unavailableGrader struct{}
+
+func (unavailableGrader) Grade(ctx context.Context, request TriviaGradingRequest) (models.TriviaGrade, error) {
+	if request.Question.ID == 2 {
+		return models.TriviaGrade{}, ErrLLMUnavailable
+	}
+	return FakeGrader{}.Grade(ctx, request)
+}
+
+func TestTriviaPipelineRequeueDoesNotBlock(t *testing.T) {
+	t.Setenv("TRIVIA_REQUEUE_DELAY", "1h")
+	pipeline := startTriviaPipeline(t, unavailableGrader{}, 1)
+
+	pipeline.submit(t, TriviaSubmissionMessage{
+		SubmissionId: "requeued",
+		QuestionIds:  []int{2},
+		Answers:      []string{"A hash table maps keys to values"},
+	})
+	pipeline.submit(t, TriviaSubmissionMessage{
+		SubmissionId: "graded",
+		QuestionIds:  []int{1},
+		Answers:      []string{"Transmission control protocol"},
+	})
+
+	// The only worker grades the next submission while the first one waits
+	// to be requeued.
+	if response := pipeline.finished(t); response.SubmissionId != "graded" || response.Status != "SUCCEEDED" {
+		t.Errorf("FINISHED = %+v, want graded SUCCEEDED", response)
+	}
+	if _, err := pipeline.submissions.Get(context.Background(), "requeued"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get of the requeued submission = %v, want ErrNotFound", err)
+	}
+}
+
+// recordingGrader records the size of the batches it grades.
+type recordingGrader struct {
+	FakeGrader
+	batches chan int
+}
+
+func (g recordingGrader) GradeBatch(ctx context.Context, requests []TriviaGradingRequest) ([]models.TriviaGrade, error) {
+	g.batches <- len(requests)
+	return g.FakeGrader.GradeBatch(ctx, requests)
+}
+
+func TestTriviaPipelineBatchesSubmissions(t *testing.T) {
+	t.Setenv("TRIVIA_BATCH_WINDOW", "1s")
+	grader := recordingGrader{batches: make(chan int, 2)}
+	pipeline := startTriviaPipeline(t, grader, 2)
+
+	for _, id := range []string{"first", "second"} {
+		pipeline.submit(t, TriviaSubmissionMessage{
+			SubmissionId: id,
+			QuestionIds:  []int{1},
+			Answers:      []string{"Transmission control protocol"},
+		})
+	}
+	for range 2 {
+		if response := pipeline.finished(t); response.Status != "SUCCEEDED" || response.Passed != 1 {
+			t.Errorf("FINISHED = %+v, want the answer passed", response)
+		}
+	}
+
+	select {
+	case size := <-grader.batches:
+		if size != 2 {
+			t.Errorf("batch of %d answers, want the answers of both submissions", size)
+		}
+	default:
+		t.Error("the answers weren't batched")
+	}
+}
+
+func TestFakeGraderGradeBatch(t *testing.T) {
+	ctx := context.Background()
+	question := &models.TriviaQuestion{ID: 1, Answer: "Transmission Control Protocol"}
+	requests := []TriviaGradingRequest{
+		{Question: question, Answer: "Transmission control protocol"},
+		{Question: question, Answer: "Transfer control"},
+	}
+
+	grades, err := FakeGrader{}.GradeBatch(ctx, requests)
+	if err != nil {
+		t.Fatalf("GradeBatch: %v", err)
+	}
+	if len(grades) != len(requests) {
+		t.Fatalf("got %d grades for %d answers", len(grades), len(requests))
+	}
+	for i, request := range requests {
+		want, _ := FakeGrader{}.Grade(ctx, request)
+		if grades[i] != want {
+			t.Errorf("grade %d = %+v, want %+v", i, grades[i], want)
+		}
+	}
+}
+
+func TestParseTriviaBatchGrade(t *testing.T) {
+	schema, err := jsonschema.GenerateSchemaForType(triviaBatchGradeOutput{})
+	if err != nil {
+		t.Fatalf("GenerateSchemaForType: %v", err)
+	}
+
+	grade := func(index, score int) string {
+		output, _ := json.Marshal(map[string]any{
+			"index":         index,
+			"pass":          score >= 50,
+			"score":         score,
+			"explanation":   "Because.",
+			"correctAnswer": "Yes.",
+		})
+		return string(output)
+	}
+	batch := func(grades ...string) string {
+		content := `{"grades":[`
+		for i, grade := range grades {
+			if i > 0 {
+				content += ","
+			}
+			content += grade
+		}
+		return content + "]}"
+	}
+
+	grades, err := parseTriviaBatchGrade(schema, batch(grade(1, 20), grade(0, 90)), 2)
+	if err != nil {
+		t.Fatalf("parseTriviaBatchGrade: %v", err)
+	}
+	if grades[0].Score != 90 || !grades[0].Pass || grades[1].Score != 20 || grades[1].Pass {
+		t.Errorf("grades = %+v, want them in the order of the answers", grades)
+	}
+
+	for _, test := range []struct {
+		name    string
+		content string
+	}{
+		{"malformed JSON", `{"grades":[`},
+		{"duplicate index", batch(grade(0, 90), grade(0, 20))},
+		{"index out of range", batch(grade(0, 90), grade(2, 20))},
+		{"negative index", batch(grade(-1, 90), grade(1, 20))},
+		{"missing grade", batch(grade(0, 90))},
+		{"extra grade", batch(grade(0, 90), grade(1, 20), grade(1, 20))},
+		{"score out of range", batch(grade(0, 90), grade(1, 120))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseTriviaBatchGrade(schema, test.content, 2); !errors.Is(err, errMalformedGrade) {
+				t.Errorf("parseTriviaBatchGrade = %v, want errMalformedGrade", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"octree.io-worker/internal/broker"
+	"octree.io-worker/internal/repository"
+	"octree.io-worker/internal/utils"
+)
+
+const triviaSubmissionsQueue = "trivia_submissions"
+
+// Execution is the outcome of running a program. Compilation errors, runtime
+// errors and timeouts are part of the outcome, not errors.
+type Execution struct {
+	Stdout            string
+	Stderr            string
+	ExecTime          int
+	WallTime          int
+	Memory            int64
+	Compiler          string
+	CompilerVersion   string
+	CompilationFailed bool
+	TimedOut          bool
+	ExitCode          int
+}
+
+// Executor runs a program wrapped in a test harness. It returns an error only
+// when the program couldn't be run at all.
+type Executor func(ctx context.Context, language string, wrappedCode string) (Execution, error)
+
+// CompilationDeps are the services the compilation pipeline depends on.
+// Execute defaults to ExecuteCode.
+type CompilationDeps struct {
+	Broker      broker.Broker
+	Submissions repository.SubmissionRepository
+	Problems    repository.ProblemRepository
+	Execute     Executor
+
+	problems    *ProblemCache
+	codeReviews bool
+}
+
+// StartCompilationWorkers declares the compilation queues on the broker and
+// starts count compilation workers, plus the lane router, the control
+// listener and the problem cache invalidation. The workers stop when ctx is
+// done.
+func StartCompilationWorkers(ctx context.Context, deps *CompilationDeps, count int) error {
+	if deps.Execute == nil {
+		deps.Execute = ExecuteCode
+	}
+	b := deps.Broker
+
+	if leaseDuration() <= 0 {
+		return fmt.Errorf("SUBMISSION_LEASE_DURATION must be positive, got %v", leaseDuration())
+	}
+
+	deps.problems = NewProblemCache(deps.Problems, LoadProblemCacheConfig())
+	go deps.problems.Watch(ctx)
+	deps.codeReviews = codeReviewsEnabled()
+
+	queues := []string{compilationRequestsQueue, CompilationResponsesQueue}
+	if deps.codeReviews {
+		queues = append(queues, codeReviewRequestsQueue)
+	}
+	for _, queue := range queues {
+		if err := b.DeclareQueue(queue, broker.QueueOptions{}); err != nil {
+			return err
+		}
+	}
+
+	laneConfig, err := LoadLaneConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load compilation lane configuration: %w", err)
+	}
+
+	controlMsgs, err := b.Subscribe(ctx, CompilationControlExchange)
+	if err != nil {
+		return err
+	}
+	go SpawnControlListener(controlMsgs)
+
+	compilationMsgs, err := b.Consume(ctx, compilationRequestsQueue, broker.ConsumeOptions{})
+	if err != nil {
+		return err
+	}
+
+	if laneConfig.Enabled() {
+		var consumers []LaneConsumer
+		for _, lane := range laneConfig.Lanes {
+			if err := b.DeclareQueue(lane.Queue, laneConfig.QueueOptions()); err != nil {
+				return err
+			}
+
+			// Keep unstarted requests on the broker so priorities and weights
+			// decide what runs next, rather than the order they were prefetched.
+			msgs, err := b.Consume(ctx, lane.Queue, broker.ConsumeOptions{Prefetch: count})
+			if err != nil {
+				return err
+			}
+
+			consumers = append(consumers, LaneConsumer{Lane: lane, Msgs: msgs})
+		}
+
+		go SpawnCompilationRouter(deps, laneConfig, compilationMsgs)
+		compilationMsgs = MergeLanes(consumers)
+	}
+
+	for i := 0; i < count; i++ {
+		go SpawnCompilationWorker(i, deps, compilationMsgs)
+	}
+
+	return nil
+}
+
+// StartTriviaWorkers declares the trivia queues and starts count trivia
+// workers, each grading one submission at a time, so the batcher can combine
+// the answers of up to count submissions. The workers stop when ctx is done.
+func StartTriviaWorkers(ctx context.Context, deps *TriviaDeps, count int) error {
+	if deps.Grader == nil {
+		grader, err := NewLLMGrader(LoadLLMConfig())
+		if err != nil {
+			return err
+		}
+		deps.Grader = grader
+	}
+	deps.prompts = NewTriviaPrompts(deps.PromptTemplates, utils.GetEnvDuration("TRIVIA_PROMPT_CACHE_TTL", time.Minute))
+	if grader, ok := deps.Grader.(ModelGrader); ok {
+		deps.model = grader.Model()
+	}
+	deps.minSimilarity = utils.GetEnvFloat("TRIVIA_GRADE_CACHE_SIMILARITY", 0)
+
+	batchGrader, ok := deps.Grader.(BatchGrader)
+	if batchSize := utils.GetEnvInt("TRIVIA_BATCH_SIZE", 8); ok && batchSize > 1 {
+		deps.batcher = newTriviaBatcher(batchGrader, batchSize, utils.GetEnvDuration("TRIVIA_BATCH_WINDOW", 250*time.Millisecond))
+		go deps.batcher.run(ctx)
+	}
+	b := deps.Broker
+
+	for _, queue := range []string{triviaSubmissionsQueue, TriviaResponsesQueue} {
+		if err := b.DeclareQueue(queue, broker.QueueOptions{}); err != nil {
+			return err
+		}
+	}
+
+	triviaMsgs, err := b.Consume(ctx, triviaSubmissionsQueue, broker.ConsumeOptions{})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		go SpawnTriviaWorker(i, deps, triviaMsgs)
+	}
+
+	return nil
+}